@@ -0,0 +1,267 @@
+package config
+
+/*
+Runtime configuration for the service.
+
+Every setting can come from four places. Later sources win:
+
+  1. built-in defaults
+  2. a YAML (.yaml/.yml) or TOML (.toml) file given by -config or TODO_CONFIG
+  3. TODO_* environment variables
+  4. command line flags
+*/
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration that reads from strings like "15s" in config files
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// Config holds the effective settings of the service
+type Config struct {
+	// Addr is the address the HTTP server listens on
+	Addr string `yaml:"addr" toml:"addr"`
+	// Database is the sqlite database path or DSN
+	Database string `yaml:"database" toml:"database"`
+	// LogLevel is one of debug, info, warn or error
+	LogLevel string `yaml:"log_level" toml:"log_level"`
+	// CORSOrigins lists the origins allowed to call the API, "*" allows any
+	CORSOrigins []string `yaml:"cors_origins" toml:"cors_origins"`
+
+	ReadTimeout  Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout  Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	// ShutdownTimeout bounds how long in-flight requests may drain on shutdown
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+
+	// Transitions lists the status changes todos may make, written
+	// "from -> to"; empty for the built-in workflow
+	Transitions []string `yaml:"transitions" toml:"transitions"`
+
+	// File is the config file the settings were read from, if any
+	File string `yaml:"-" toml:"-"`
+}
+
+// Default returns the built-in configuration
+func Default() Config {
+	return Config{
+		Addr:            ":8080",
+		Database:        "db/todo.db",
+		LogLevel:        "info",
+		CORSOrigins:     []string{"*"},
+		ReadTimeout:     Duration{15 * time.Second},
+		WriteTimeout:    Duration{15 * time.Second},
+		IdleTimeout:     Duration{60 * time.Second},
+		ShutdownTimeout: Duration{10 * time.Second},
+	}
+}
+
+// setting binds one configuration value to its flag and environment variable
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"addr", "TODO_ADDR", "address to listen on", func(c *Config, v string) error {
+		c.Addr = v
+		return nil
+	}},
+	{"db", "TODO_DB", "sqlite database path or DSN", func(c *Config, v string) error {
+		c.Database = v
+		return nil
+	}},
+	{"log-level", "TODO_LOG_LEVEL", "log level: debug, info, warn or error", func(c *Config, v string) error {
+		c.LogLevel = v
+		return nil
+	}},
+	{"cors-origins", "TODO_CORS_ORIGINS", "comma separated list of allowed CORS origins", func(c *Config, v string) error {
+		c.CORSOrigins = splitList(v)
+		return nil
+	}},
+	{"read-timeout", "TODO_READ_TIMEOUT", "maximum duration for reading a request", func(c *Config, v string) error {
+		return c.ReadTimeout.UnmarshalText([]byte(v))
+	}},
+	{"write-timeout", "TODO_WRITE_TIMEOUT", "maximum duration for writing a response", func(c *Config, v string) error {
+		return c.WriteTimeout.UnmarshalText([]byte(v))
+	}},
+	{"idle-timeout", "TODO_IDLE_TIMEOUT", "how long to keep idle connections open", func(c *Config, v string) error {
+		return c.IdleTimeout.UnmarshalText([]byte(v))
+	}},
+	{"shutdown-timeout", "TODO_SHUTDOWN_TIMEOUT", "how long to drain in-flight requests on shutdown", func(c *Config, v string) error {
+		return c.ShutdownTimeout.UnmarshalText([]byte(v))
+	}},
+	{"transitions", "TODO_TRANSITIONS", "comma separated list of allowed status changes, e.g. \"open -> closed\"", func(c *Config, v string) error {
+		c.Transitions = splitList(v)
+		return nil
+	}},
+}
+
+// Load builds the configuration from the command line args (without the
+// program name), the environment and the optional config file. It returns
+// the arguments left after the flags.
+func Load(args []string) (Config, []string, error) {
+	fs := flag.NewFlagSet("go-todo", flag.ContinueOnError)
+	configFile := fs.String("config", "", "path to a YAML or TOML config file (env TODO_CONFIG)")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.flag] = fs.String(s.flag, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	cfg := Default()
+
+	if *configFile == "" {
+		*configFile = os.Getenv("TODO_CONFIG")
+	}
+	if *configFile != "" {
+		if err := cfg.readFile(*configFile); err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(&cfg, v); err != nil {
+				return Config{}, nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && err == nil {
+				if e := s.set(&cfg, *values[s.flag]); e != nil {
+					err = fmt.Errorf("-%s: %w", s.flag, e)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("config: %s: unsupported file type, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+
+	c.File = path
+	return nil
+}
+
+// Validate checks that the settings are usable
+func (c Config) Validate() error {
+	if c.Addr == "" {
+		return fmt.Errorf("config: addr must not be empty")
+	}
+	if c.Database == "" {
+		return fmt.Errorf("config: database must not be empty")
+	}
+	if _, err := c.SlogLevel(); err != nil {
+		return err
+	}
+	for name, d := range map[string]Duration{"read_timeout": c.ReadTimeout, "write_timeout": c.WriteTimeout, "idle_timeout": c.IdleTimeout, "shutdown_timeout": c.ShutdownTimeout} {
+		if d.Duration < 0 {
+			return fmt.Errorf("config: %s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// SlogLevel returns LogLevel as a slog.Level
+func (c Config) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return level, fmt.Errorf("config: log level %q must be debug, info, warn or error", c.LogLevel)
+	}
+	return level, nil
+}
+
+// Print writes the effective configuration to w
+func (c Config) Print(w io.Writer) error {
+	file := c.File
+	if file == "" {
+		file = "(none)"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "config file\t%s\n", file)
+	fmt.Fprintf(tw, "addr\t%s\n", c.Addr)
+	fmt.Fprintf(tw, "database\t%s\n", c.Database)
+	fmt.Fprintf(tw, "log level\t%s\n", c.LogLevel)
+	fmt.Fprintf(tw, "cors origins\t%s\n", strings.Join(c.CORSOrigins, ", "))
+	fmt.Fprintf(tw, "read timeout\t%s\n", c.ReadTimeout)
+	fmt.Fprintf(tw, "write timeout\t%s\n", c.WriteTimeout)
+	fmt.Fprintf(tw, "idle timeout\t%s\n", c.IdleTimeout)
+	fmt.Fprintf(tw, "shutdown timeout\t%s\n", c.ShutdownTimeout)
+	transitions := "(default)"
+	if len(c.Transitions) > 0 {
+		transitions = strings.Join(c.Transitions, ", ")
+	}
+	fmt.Fprintf(tw, "transitions\t%s\n", transitions)
+	return tw.Flush()
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
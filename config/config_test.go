@@ -0,0 +1,111 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaults(t *testing.T) {
+	cfg, args, err := Load(nil)
+	if err != nil {
+		t.Fatal("Error loading defaults", err)
+	}
+	if cfg.Addr != ":8080" {
+		t.Error("Default addr is not :8080", cfg.Addr)
+	}
+	if len(args) != 0 {
+		t.Error("Unexpected remaining args", args)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	file := writeFile(t, "todo.yaml", `
+addr: ":7000"
+database: /var/lib/todo/file.db
+log_level: debug
+cors_origins: ["https://file.example"]
+read_timeout: 3s
+`)
+	t.Setenv("TODO_CONFIG", file)
+	t.Setenv("TODO_DB", "/var/lib/todo/env.db")
+	t.Setenv("TODO_READ_TIMEOUT", "4s")
+
+	cfg, args, err := Load([]string{"-read-timeout", "5s", "migrate", "status"})
+	if err != nil {
+		t.Fatal("Error loading config", err)
+	}
+
+	if cfg.Addr != ":7000" {
+		t.Error("File value did not override the default", cfg.Addr)
+	}
+	if cfg.Database != "/var/lib/todo/env.db" {
+		t.Error("Environment did not override the file", cfg.Database)
+	}
+	if cfg.ReadTimeout.Duration != 5*time.Second {
+		t.Error("Flag did not override the environment", cfg.ReadTimeout)
+	}
+	if len(cfg.CORSOrigins) != 1 || cfg.CORSOrigins[0] != "https://file.example" {
+		t.Error("CORS origins not read from file", cfg.CORSOrigins)
+	}
+	if cfg.WriteTimeout.Duration != 15*time.Second {
+		t.Error("Unset value lost its default", cfg.WriteTimeout)
+	}
+	if len(args) != 2 || args[0] != "migrate" {
+		t.Error("Remaining args not returned", args)
+	}
+}
+
+func TestTOMLFile(t *testing.T) {
+	file := writeFile(t, "todo.toml", `
+addr = "127.0.0.1:9000"
+cors_origins = ["https://a.example", "https://b.example"]
+idle_timeout = "2m"
+transitions = ["open -> closed", "closed -> open"]
+`)
+
+	cfg, _, err := Load([]string{"-config", file})
+	if err != nil {
+		t.Fatal("Error loading config", err)
+	}
+	if cfg.Addr != "127.0.0.1:9000" {
+		t.Error("Addr not read from TOML", cfg.Addr)
+	}
+	if len(cfg.CORSOrigins) != 2 {
+		t.Error("CORS origins not read from TOML", cfg.CORSOrigins)
+	}
+	if cfg.IdleTimeout.Duration != 2*time.Minute {
+		t.Error("Idle timeout not read from TOML", cfg.IdleTimeout)
+	}
+	if len(cfg.Transitions) != 2 || cfg.Transitions[0] != "open -> closed" {
+		t.Error("Transitions not read from TOML", cfg.Transitions)
+	}
+	if cfg.File != file {
+		t.Error("Config file not recorded", cfg.File)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	if _, _, err := Load([]string{"-log-level", "loud"}); err == nil {
+		t.Error("Expected an error for an unknown log level")
+	}
+
+	t.Setenv("TODO_WRITE_TIMEOUT", "soon")
+	if _, _, err := Load(nil); err == nil {
+		t.Error("Expected an error for an unparsable timeout")
+	}
+
+	if _, _, err := Load([]string{"-config", writeFile(t, "todo.ini", "addr=:1")}); err == nil {
+		t.Error("Expected an error for an unsupported config file type")
+	}
+}
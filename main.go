@@ -1,15 +1,105 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"go-todo/config"
+	"go-todo/middleware"
 	"go-todo/router"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	_ "time/tzdata" // time zones for due dates, also where the host has none
 )
 
 func main() {
-	r := router.Router()
-	fmt.Println("Starting server on the port 8080...")
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	log.Fatal(http.ListenAndServe(":8080", r))
+	level, _ := cfg.SlogLevel()
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(cfg.Database, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	fmt.Println("Effective configuration:")
+	cfg.Print(os.Stdout)
+
+	if err := serve(cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// serve runs the API until SIGINT or SIGTERM, then drains in-flight requests
+// for up to cfg.ShutdownTimeout before closing the database.
+func serve(cfg config.Config) error {
+	workflow, err := middleware.ParseWorkflow(cfg.Transitions)
+	if err != nil {
+		return fmt.Errorf("invalid transitions: %w", err)
+	}
+
+	store, err := middleware.OpenSQLiteStore(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("unable to open the database: %w", err)
+	}
+	defer store.Close()
+	if err := store.SetWorkflow(context.Background(), workflow); err != nil {
+		return fmt.Errorf("invalid transitions: %w", err)
+	}
+
+	// every request context derives from base, which is cancelled once the
+	// server gives up draining so that long queries are aborted
+	base, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	server := &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      router.Router(middleware.NewHandler(store), cfg.CORSOrigins),
+		ReadTimeout:  cfg.ReadTimeout.Duration,
+		WriteTimeout: cfg.WriteTimeout.Duration,
+		IdleTimeout:  cfg.IdleTimeout.Duration,
+		BaseContext:  func(net.Listener) context.Context { return base },
+	}
+
+	stop, cancelSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancelSignals()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		fmt.Printf("Starting server on %s...\n", cfg.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-stop.Done():
+	}
+
+	slog.Info("shutting down, draining connections", "timeout", cfg.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("connections did not drain in time, aborting them", "err", err)
+		cancelRequests()
+		server.Close()
+	}
+
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	slog.Info("server stopped")
+	return nil
 }
@@ -1,308 +1,1095 @@
 package middleware
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"go-todo/models"
-	"log"
-)
+	"log/slog"
+	"strings"
+	"time"
 
-func initialiseToDo(db *sql.DB) error {
-	// create todos table
-	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS todos (id INTEGER PRIMARY KEY, title TEXT, description TEXT, createdAt TIMESTAMP default (strftime('%s', 'now')), updatedAt TIMESTAMP DEFAULT (strftime('%s', 'now')), status INTEGER)")
-	checkErr(err)
+	"github.com/mattn/go-sqlite3"
+)
 
-	_, err = statement.Exec()
-	return err
+// SQLiteStore is a TodoStore backed by a single long-lived sqlite connection pool
+type SQLiteStore struct {
+	db *sql.DB
+	// search is set when the todos_fts full-text index exists
+	search bool
+	// workflow holds the status changes PatchTodo accepts
+	workflow transitions
 }
 
-func initialiseTag(db *sql.DB) error {
-	// create tags table
-	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS tags (id INTEGER PRIMARY KEY, name STRING, createdAt TIMESTAMP default (strftime('%s', 'now')))")
-	checkErr(err)
+var _ TodoStore = (*SQLiteStore)(nil)
 
-	_, err = statement.Exec()
-	return err
+// driverName is the sqlite3 driver with the functions of registerFunctions
+// on every connection
+const driverName = "sqlite3_todo"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{ConnectHook: registerFunctions})
 }
 
-func initialiseTodoTag(db *sql.DB) error {
-	// create todos table
-	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS todos_tags (id INTEGER PRIMARY KEY, todo_id INTEGER, tag_id INTEGER)")
-	checkErr(err)
+// registerFunctions lets SQL, the migrations in particular, normalise tag
+// names exactly as the store does: tag_name is normalizeTagName and tag_key
+// is tagKey. due_instant is dueInstant, for sorting by due date.
+func registerFunctions(conn *sqlite3.SQLiteConn) error {
+	if err := conn.RegisterFunc("tag_name", normalizeTagName, true); err != nil {
+		return err
+	}
+	if err := conn.RegisterFunc("tag_key", tagKey, true); err != nil {
+		return err
+	}
+	return conn.RegisterFunc("due_instant", dueInstant, true)
+}
 
-	_, err = statement.Exec()
-	return err
+// OpenDB opens and pings the sqlite database at path without touching the schema
+func OpenDB(path string) (*sql.DB, error) {
+	// Open the connection, foreign keys are off in sqlite unless every
+	// connection asks for them
+	db, err := sql.Open(driverName, withConnOptions(path))
+	if err != nil {
+		return nil, err
+	}
+
+	// check the connection
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
 }
 
-func checkOrCreateTables(db *sql.DB) error {
-	if err := initialiseToDo(db); err != nil {
-		return err
+// connOptions are the go-sqlite3 options of every connection: foreign keys
+// on, and transactions that take the write lock as they begin. Transactions
+// read before they write, and a deferred one that finds another writer
+// holding the lock by then fails at once with "database is locked" instead
+// of waiting for it.
+const connOptions = "_foreign_keys=1&_txlock=immediate"
+
+// withConnOptions adds connOptions to a database path, keeping any options
+// it already has
+func withConnOptions(path string) string {
+	if strings.Contains(path, "?") {
+		return path + "&" + connOptions
 	}
+	return path + "?" + connOptions
+}
 
-	if err := initialiseTag(db); err != nil {
-		return err
+// OpenSQLiteStore opens the sqlite database at path and applies any pending
+// migrations. Status changes follow the default workflow until SetWorkflow.
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := OpenDB(path)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := initialiseTodoTag(db); err != nil {
-		return err
+	ctx := context.Background()
+	migrator, err := NewMigrator(db)
+	if err == nil {
+		err = migrator.Up(ctx)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	return nil
+	store := &SQLiteStore{db: db}
+	if store.search, err = searchIndexUsable(ctx, db, migrator); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
 }
 
-// create connection with sqlite db
-func createConnection() *sql.DB {
-	// Open the connection
-	db, err := sql.Open("sqlite3", "../db/todo.db")
-	checkErr(err)
+// searchIndexUsable reports whether the full-text index exists. A database
+// indexed by an FTS5 enabled build cannot be written by one without FTS5,
+// because the sync triggers would fail, so that combination is an error.
+func searchIndexUsable(ctx context.Context, db *sql.DB, migrator *Migrator) (bool, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM sqlite_master WHERE name='todos_fts'").Scan(&exists); err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, nil
+	}
 
-	// check the connection
-	err = db.Ping()
-	checkErr(err)
+	supported, err := migrator.supports(ctx, "fts5")
+	if err == nil && !supported {
+		err = fmt.Errorf("the database has a full-text index but this build lacks FTS5, rebuild with -tags sqlite_fts5")
+	}
+	return supported, err
+}
 
-	err = checkOrCreateTables(db)
-	checkErr(err)
-	// return the connection
-	return db
+// Close closes the underlying database handle
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
 }
 
-//------------------------- handler functions ----------------
+//------------------------- store functions ----------------
+
+// todoColumns selects every column of a todo from the todos table aliased
+// t, in the order of todoFields
+const todoColumns = "t.id, t.title, t.description, t.createdAt, t.updatedAt, t.status, t.priority, COALESCE(t.dueAt, ''), COALESCE(t.parentId, 0), t.autoClose, COALESCE(t.recurrence, '')"
+
+// todoFields returns the scan destinations for todoColumns
+func todoFields(todo *models.ToDo) []interface{} {
+	return []interface{}{&todo.ID, &todo.Title, &todo.Description, &todo.CreatedAt, &todo.UpdatedAt, &todo.Status, &todo.Priority, &todo.DueAt, &todo.ParentID, &todo.AutoClose, &todo.Recurrence}
+}
 
-func insertTodo(todo models.ToDo) (models.ToDo, error) {
-	db := createConnection()
-	defer db.Close()
+// InsertTodo creates a new todo in the first open status and returns the
+// stored entry
+func (s *SQLiteStore) InsertTodo(ctx context.Context, todo models.ToDo) (models.ToDo, error) {
+	dueAt, dueTime, err := dueColumns(todo.DueAt)
+	if err != nil {
+		return models.ToDo{}, err
+	}
+	rrule, err := recurrenceColumn(todo.Recurrence)
+	if err != nil {
+		return models.ToDo{}, err
+	}
+	if rrule.Valid && !dueAt.Valid {
+		return models.ToDo{}, invalidf("a recurring todo needs a dueAt")
+	}
 
-	statement, err := db.Prepare("INSERT INTO todos (title, description, status) VALUES (?, ?, 0)")
-	checkErr(err)
+	var id int64
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		if todo.ParentID != 0 {
+			if err := checkParent(ctx, tx, 0, todo.ParentID); err != nil {
+				return err
+			}
+		}
 
-	response, err := statement.Exec(todo.Title, todo.Description)
-	checkErr(err)
+		response, err := tx.ExecContext(ctx, "INSERT INTO todos (title, description, status, priority, dueAt, dueTime, parentId, autoClose, recurrence) VALUES (?, ?, "+firstOpenStatus+", ?, ?, ?, ?, ?, ?)",
+			todo.Title, todo.Description, todo.Priority, dueAt, dueTime, nullID(todo.ParentID), todo.AutoClose, rrule)
+		if err != nil {
+			return storeError(err)
+		}
 
-	id, err := response.LastInsertId()
+		id, err = response.LastInsertId()
+		return err
+	})
 	if err != nil {
-		log.Fatalf("Unable to execute the query. %v\n", err)
+		return models.ToDo{}, err
 	}
 
-	fmt.Printf("Inserted a single record %v\n", id)
+	slog.Debug("inserted a single record", "table", "todos", "id", id)
 
-	newEntry, err := getTodo(id)
-	checkErr(err)
-	// return the inserted id
-	return newEntry, err
+	// return the inserted entry
+	return s.GetTodo(ctx, id)
 }
 
-func getTodo(id int64) (models.ToDo, error) {
-	db := createConnection()
-	defer db.Close()
+// GetTodo returns the todo with its tags and the progress of its subtasks
+func (s *SQLiteStore) GetTodo(ctx context.Context, id int64) (models.ToDo, error) {
+	return getTodo(ctx, s.db, id)
+}
 
+func getTodo(ctx context.Context, q querier, id int64) (models.ToDo, error) {
 	var todo models.ToDo
-	row := db.QueryRow("SELECT * FROM todos WHERE id=?", id)
+	row := q.QueryRowContext(ctx, "SELECT "+todoColumns+" FROM todos t WHERE id=?", id)
 
-	err := row.Scan(&todo.ID, &todo.Title, &todo.Description, &todo.CreatedAt, &todo.UpdatedAt, &todo.Status)
+	err := row.Scan(todoFields(&todo)...)
 
 	switch err {
 	case sql.ErrNoRows:
-		fmt.Println("No rows were returned!")
-		return todo, nil
+		return todo, fmt.Errorf("todo %v: %w", id, ErrNotFound)
 	case nil:
-		tags, err := getTagsOfTodo(id)
+		tags, err := tagsOfTodo(ctx, q, id)
+		todo.Tags = tags
 		if err != nil {
-			fmt.Printf("No tags for todo id: %v\n", id)
+			return todo, err
 		}
-		todo.Tags = tags
 
-		return todo, err
+		todos := []models.ToDo{todo}
+		err = attachProgress(ctx, q, todos)
+		return todos[0], err
 	default:
-		log.Fatalf("getTodo: Unable to scan the row. %v\n", err)
-		return todo, err
+		return todo, fmt.Errorf("getTodo: unable to scan the row: %w", err)
 	}
-
 }
 
-func getAllTodos() ([]models.ToDo, error) {
-	db := createConnection()
-	defer db.Close()
+// GetAllTodos returns one page of the todos matching filter, in filter's sort order
+func (s *SQLiteStore) GetAllTodos(ctx context.Context, filter TodoFilter, page Page) ([]models.ToDo, string, error) {
+	after, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
 
-	rows, err := db.Query("SELECT * FROM todos")
-	checkErr(err)
+	sortName := filter.sortName()
+	sort, ok := todoSorts[filter.Sort]
+	if !ok {
+		sort = todoSorts[DefaultTodoSort]
+	}
+	if filter.Sort == "dueAt" {
+		sort.column = dueSortKey(filter.location())
+		sort.key = sort.column
+	}
+	op, dir := ">", "ASC"
+	if filter.Desc {
+		op, dir = "<", "DESC"
+	}
 
-	var todos []models.ToDo
-	var todo models.ToDo
+	conds, args := filter.where()
+	if page.Cursor != "" {
+		if after.Sort == "" {
+			after.Sort = "id"
+		}
+		if after.Sort != sortName {
+			return nil, "", errBadRequest{fmt.Errorf("cursor belongs to sort %q, not %q", after.Sort, sortName)}
+		}
+
+		// keyset condition: strictly after the last row in (sort key, id) order
+		if sort.column == "id" {
+			conds = append(conds, "id "+op+" ?")
+			args = append(args, after.ID)
+		} else {
+			conds = append(conds, "("+sort.column+", id) "+op+" (?, ?)")
+			args = append(args, after.Key, after.ID)
+		}
+	}
+
+	query := "SELECT " + todoColumns + ", " + sort.key + " FROM todos t"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY " + sort.column + " " + dir + ", id " + dir + " LIMIT ?"
+
+	// fetch one extra row to learn whether there is a next page
+	limit := page.limit()
+	args = append(args, limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	todos := []models.ToDo{}
+	var keys []interface{}
 	for rows.Next() {
-		if err := rows.Scan(&todo.ID, &todo.Title, &todo.Description, &todo.CreatedAt, &todo.UpdatedAt, &todo.Status); err != nil {
-			log.Fatalf("getAllTodos: Unable to scan the row. %v\n", err)
+		var todo models.ToDo
+		var key interface{}
+		if err := rows.Scan(append(todoFields(&todo), &key)...); err != nil {
+			return nil, "", fmt.Errorf("getAllTodos: unable to scan the row: %w", err)
+		}
+		if b, ok := key.([]byte); ok {
+			key = string(b)
 		}
 		todos = append(todos, todo)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
 	}
-	return todos, err
-}
 
-func updateTodo(id int64, todo models.ToDo) (models.ToDo, error) {
-	db := createConnection()
-	defer db.Close()
+	var next string
+	if len(todos) > limit {
+		todos = todos[:limit]
+		next = encodeCursor(cursor{ID: todos[limit-1].ID, Sort: sortName, Key: keys[limit-1]})
+	}
 
-	fmt.Printf("received %v\n", todo)
-	statement, err := db.Prepare("UPDATE todos SET title=?, description=?, status=?, updatedAt=strftime('%s', 'now') WHERE id=?")
-	checkErr(err)
+	if !filter.WithoutTags {
+		if err := s.attachTags(ctx, todos); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := attachProgress(ctx, s.db, todos); err != nil {
+		return nil, "", err
+	}
+	return todos, next, nil
+}
 
-	response, err := statement.Exec(todo.Title, todo.Description, todo.Status, id)
-	if err != nil {
-		log.Fatalf("Unable to execute the query. %v\n", err)
+// attachTags fills in the tags of every todo with a single query
+func (s *SQLiteStore) attachTags(ctx context.Context, todos []models.ToDo) error {
+	if len(todos) == 0 {
+		return nil
 	}
 
-	rowsAffected, err := response.RowsAffected()
+	index := make(map[int64]int, len(todos))
+	args := make([]interface{}, 0, len(todos))
+	for i, todo := range todos {
+		index[todo.ID] = i
+		args = append(args, todo.ID)
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT jt.todo_id, "+tagColumns+" FROM todos_tags jt JOIN tags t ON t.id = jt.tag_id WHERE jt.todo_id IN ("+placeholders(len(args))+") ORDER BY jt.todo_id, t.id", args...)
 	if err != nil {
-		log.Fatalf("Error while checking the affected rows. %v\n", err)
+		return err
 	}
-	fmt.Printf("Total rows/record affected %v\n", rowsAffected)
+	defer rows.Close()
 
-	updatedEntry, err := getTodo(id)
-	checkErr(err)
+	for rows.Next() {
+		var todoID int64
+		var tag models.Tag
+		if err := rows.Scan(append([]interface{}{&todoID}, tagFields(&tag)...)...); err != nil {
+			return fmt.Errorf("attachTags: unable to scan the row: %w", err)
+		}
+		i := index[todoID]
+		todos[i].Tags = append(todos[i].Tags, tag)
+	}
+	return rows.Err()
+}
 
-	return updatedEntry, err
+// UpdateTodo overwrites every field of a todo that a client can write. force
+// skips the check for open blockers, see PatchTodo.
+func (s *SQLiteStore) UpdateTodo(ctx context.Context, id int64, todo models.ToDo, force bool) (models.ToDo, error) {
+	return s.PatchTodo(ctx, id, TodoPatch{
+		Title:       &todo.Title,
+		Description: &todo.Description,
+		Status:      &todo.Status,
+		Priority:    &todo.Priority,
+		DueAt:       &todo.DueAt,
+		ParentID:    &todo.ParentID,
+		AutoClose:   &todo.AutoClose,
+		Recurrence:  &todo.Recurrence,
+		Force:       force,
+	})
 }
 
-func deleteTodo(id int64) (int64, error) {
-	db := createConnection()
-	defer db.Close()
+// PatchTodo writes the fields set in patch and bumps updatedAt. Status
+// changes must follow the workflow of the store, and a todo that waits on
+// open blockers cannot be started or finished unless the patch forces it.
+// Moving a recurring todo to a done status schedules its next occurrence,
+// see scheduleNext, and finishing or moving a subtask may close its
+// parents, see closeFinished.
+func (s *SQLiteStore) PatchTodo(ctx context.Context, id int64, patch TodoPatch) (models.ToDo, error) {
+	if patch.IsEmpty() {
+		return s.GetTodo(ctx, id)
+	}
+
+	var sets []string
+	var args []interface{}
+	if patch.Title != nil {
+		sets = append(sets, "title=?")
+		args = append(args, *patch.Title)
+	}
+	if patch.Description != nil {
+		sets = append(sets, "description=?")
+		args = append(args, *patch.Description)
+	}
+	if patch.Status != nil {
+		sets = append(sets, "status=?")
+		args = append(args, *patch.Status)
+	}
+	if patch.Priority != nil {
+		sets = append(sets, "priority=?")
+		args = append(args, *patch.Priority)
+	}
+	if patch.DueAt != nil {
+		dueAt, dueTime, err := dueColumns(*patch.DueAt)
+		if err != nil {
+			return models.ToDo{}, err
+		}
+		sets = append(sets, "dueAt=?", "dueTime=?")
+		args = append(args, dueAt, dueTime)
+	}
+	if patch.ParentID != nil {
+		sets = append(sets, "parentId=?")
+		args = append(args, nullID(*patch.ParentID))
+	}
+	if patch.AutoClose != nil {
+		sets = append(sets, "autoClose=?")
+		args = append(args, *patch.AutoClose)
+	}
+	if patch.Recurrence != nil {
+		rrule, err := recurrenceColumn(*patch.Recurrence)
+		if err != nil {
+			return models.ToDo{}, err
+		}
+		sets = append(sets, "recurrence=?")
+		args = append(args, rrule)
+	}
+	sets = append(sets, "updatedAt=strftime('%s', 'now')")
+	args = append(args, id)
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		if patch.Precondition != nil {
+			current, err := getTodo(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if err := patch.Precondition(current); err != nil {
+				return err
+			}
+		}
+		var oldParent sql.NullInt64
+		if patch.ParentID != nil {
+			if *patch.ParentID != 0 {
+				if err := checkParent(ctx, tx, id, *patch.ParentID); err != nil {
+					return err
+				}
+			}
+			err := tx.QueryRowContext(ctx, "SELECT parentId FROM todos WHERE id=?", id).Scan(&oldParent)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+		}
+		closing := false
+		if patch.Status != nil {
+			from, to, err := s.workflow.change(ctx, tx, id, *patch.Status)
+			if err != nil {
+				return err
+			}
+			if !patch.Force {
+				if err := checkUnblocked(ctx, tx, id, from, to); err != nil {
+					return err
+				}
+			}
+			closing = from.Category != models.CategoryDone && to.Category == models.CategoryDone
+		}
+
+		response, err := tx.ExecContext(ctx, "UPDATE todos SET "+strings.Join(sets, ", ")+" WHERE id=?", args...)
+		if err != nil {
+			return storeError(err)
+		}
 
-	statement, err := db.Prepare("DELETE FROM todos WHERE id=?")
-	checkErr(err)
+		rowsAffected, err := response.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return fmt.Errorf("todo %v: %w", id, ErrNotFound)
+		}
 
-	response, err := statement.Exec(id)
+		if patch.Recurrence != nil || patch.DueAt != nil {
+			if err := checkRecurrence(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+		if closing {
+			if err := scheduleNext(ctx, tx, id); err != nil {
+				return err
+			}
+			if err := closeFinishedParents(ctx, tx, s.workflow, id); err != nil {
+				return err
+			}
+		}
+		// moving a subtask may finish the parent it leaves or joins
+		if patch.ParentID != nil && oldParent.Int64 != *patch.ParentID {
+			for _, parentID := range []int64{oldParent.Int64, *patch.ParentID} {
+				if parentID == 0 {
+					continue
+				}
+				if err := closeFinished(ctx, tx, s.workflow, parentID); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		log.Fatalf("Unable to execute the query. %v\n", err)
+		return models.ToDo{}, err
 	}
 
-	rowsAffected, err := response.RowsAffected()
+	return s.GetTodo(ctx, id)
+}
+
+// DeleteTodo deletes a todo and returns the number of affected rows. The
+// parent it leaves closes if it is now finished, see closeFinished.
+func (s *SQLiteStore) DeleteTodo(ctx context.Context, id int64) (int64, error) {
+	var rowsAffected int64
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var parentID sql.NullInt64
+		err := tx.QueryRowContext(ctx, "SELECT parentId FROM todos WHERE id=?", id).Scan(&parentID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("todo %v: %w", id, ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+
+		response, err := tx.ExecContext(ctx, "DELETE FROM todos WHERE id=?", id)
+		if err != nil {
+			return err
+		}
+		if rowsAffected, err = response.RowsAffected(); err != nil {
+			return err
+		}
 
+		// the subtasks left to the parent may all be done
+		if parentID.Valid {
+			return closeFinished(ctx, tx, s.workflow, parentID.Int64)
+		}
+		return nil
+	})
+	return rowsAffected, err
+}
+
+// SearchTodos ranks todos matching an FTS5 query with bm25, weighting title
+// matches above description matches
+func (s *SQLiteStore) SearchTodos(ctx context.Context, query string, limit int) ([]models.SearchResult, error) {
+	if !s.search {
+		return nil, fmt.Errorf("%w: full-text search needs a build with -tags sqlite_fts5", ErrUnavailable)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+todoColumns+`,
+			bm25(todos_fts, 10.0, 1.0),
+			highlight(todos_fts, 0, '<mark>', '</mark>'),
+			snippet(todos_fts, 1, '<mark>', '</mark>', '…', 16)
+		FROM todos_fts JOIN todos t ON t.id = todos_fts.rowid
+		WHERE todos_fts MATCH ?
+		ORDER BY bm25(todos_fts, 10.0, 1.0), t.id
+		LIMIT ?`, query, limit)
 	if err != nil {
-		log.Fatalf("Error while checking the affected rows. %v\n", err)
+		return nil, searchError(err)
 	}
+	defer rows.Close()
 
-	fmt.Printf("Total rows/record affected %v\n", rowsAffected)
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var res models.SearchResult
+		if err := rows.Scan(append(todoFields(&res.ToDo), &res.Rank, &res.TitleHighlight, &res.Snippet)...); err != nil {
+			return nil, fmt.Errorf("searchTodos: unable to scan the row: %w", err)
+		}
+		results = append(results, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, searchError(err)
+	}
 
-	return rowsAffected, err
+	todos := make([]models.ToDo, len(results))
+	for i := range results {
+		todos[i] = results[i].ToDo
+	}
+	if err := s.attachTags(ctx, todos); err != nil {
+		return nil, err
+	}
+	if err := attachProgress(ctx, s.db, todos); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].ToDo = todos[i]
+	}
+	return results, nil
+}
+
+// searchError reports FTS5 query syntax errors as bad requests
+func searchError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, prefix := range []string{"fts5:", "unterminated string", "no such column", "unknown special query"} {
+		if strings.HasPrefix(err.Error(), prefix) {
+			return errBadRequest{fmt.Errorf("invalid search query: %w", err)}
+		}
+	}
+	return err
 }
 
-func getTagsOfTodo(id int64) ([]models.Tag, error) {
-	db := createConnection()
-	defer db.Close()
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
 
-	statement, err := db.Prepare("SELECT t.id, t.name, t.createdAt FROM todos_tags jt JOIN tags t on t.id = jt.tag_id WHERE jt.todo_id=?")
-	checkErr(err)
+// withTx runs fn in a transaction, committing when it returns nil
+func (s *SQLiteStore) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetTagsOfTodo returns the tags associated with a todo
+func (s *SQLiteStore) GetTagsOfTodo(ctx context.Context, todoID int64) ([]models.Tag, error) {
+	return tagsOfTodo(ctx, s.db, todoID)
+}
+
+func tagsOfTodo(ctx context.Context, q querier, todoID int64) ([]models.Tag, error) {
+	rows, err := q.QueryContext(ctx, "SELECT "+tagColumns+" FROM todos_tags jt JOIN tags t ON t.id = jt.tag_id WHERE jt.todo_id=? ORDER BY t.id", todoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	rows, err := statement.Query(id)
-	checkErr(err)
 	var tags []models.Tag
-	var tag models.Tag
 	for rows.Next() {
-		if err := rows.Scan(&tag.ID, &tag.Name, &tag.CreatedAt); err != nil {
-			log.Fatalf("getTagsOfTodo: Unable to scan the row. %v\n", err)
+		var tag models.Tag
+		if err := rows.Scan(tagFields(&tag)...); err != nil {
+			return nil, fmt.Errorf("getTagsOfTodo: unable to scan the row: %w", err)
 		}
 		tags = append(tags, tag)
 	}
-	return tags, err
+	return tags, rows.Err()
 }
 
-func insertTag(tag models.Tag) (models.Tag, error) {
-	db := createConnection()
-	defer db.Close()
+// tagColumns selects every column of a tag from the tags table aliased t,
+// in the order of tagFields
+const tagColumns = "t.id, t.name, t.color, t.description, t.createdAt, t.updatedAt"
 
-	statement, err := db.Prepare("INSERT INTO tags (name) VALUES (?)")
-	checkErr(err)
-
-	response, err := statement.Exec(tag.Name)
-	checkErr(err)
+// tagFields returns the scan destinations for tagColumns
+func tagFields(tag *models.Tag) []interface{} {
+	return []interface{}{&tag.ID, &tag.Name, &tag.Color, &tag.Description, &tag.CreatedAt, &tag.UpdatedAt}
+}
 
-	id, err := response.LastInsertId()
+// InsertTag returns the tag with the given name, creating it first if no tag
+// has that name yet. Names compare by tagKey.
+func (s *SQLiteStore) InsertTag(ctx context.Context, tag models.Tag) (models.Tag, error) {
+	name := normalizeTagName(tag.Name)
+	response, err := s.db.ExecContext(ctx, "INSERT INTO tags (name, nameKey, color, description, updatedAt) VALUES (?, ?, ?, ?, strftime('%s', 'now')) ON CONFLICT DO NOTHING", name, tagKey(name), tag.Color, tag.Description)
 	if err != nil {
-		log.Fatalf("Unable to execute the query. %v\n", err)
+		return models.Tag{}, storeError(err)
 	}
 
-	fmt.Printf("Inserted a single record %v\n", id)
-	newEntry, err := getTag(id)
-	checkErr(err)
-	// return the inserted id
-	return newEntry, err
+	if n, _ := response.RowsAffected(); n > 0 {
+		id, _ := response.LastInsertId()
+		slog.Debug("inserted a single record", "table", "tags", "id", id)
+	}
+
+	// return the stored entry
+	return s.GetTagByName(ctx, name)
 }
 
-func getTag(id int64) (models.Tag, error) {
-	db := createConnection()
-	defer db.Close()
+// tagSeparator splits tag names into namespaces: team/backend is a child of
+// team
+const tagSeparator = "/"
+
+// normalizeTagName trims the whitespace around a tag name and around each
+// of its slash separated namespace segments, dropping empty segments
+func normalizeTagName(name string) string {
+	var segments []string
+	for _, segment := range strings.Split(name, tagSeparator) {
+		if segment = strings.TrimSpace(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return strings.Join(segments, tagSeparator)
+}
+
+// tagKey is what tag names are compared by, and unique on, in the nameKey
+// column: the normalised name in lower case. Unlike COLLATE NOCASE it folds
+// letters beyond ASCII, so Équipe and équipe are the same tag.
+func tagKey(name string) string {
+	return strings.ToLower(normalizeTagName(name))
+}
+
+// tagSubtree returns a condition matching the tag with the given key and
+// every tag below it, for the tag key column col
+func tagSubtree(col, key string) (string, []interface{}) {
+	return "(" + col + " = ? OR " + col + ` LIKE ? ESCAPE '\')`,
+		[]interface{}{key, likeEscaper.Replace(key) + tagSeparator + "%"}
+}
 
-	row := db.QueryRow("SELECT * FROM tags WHERE id=?", id)
+// GetTagByName returns the tag with the given name, ignoring case
+func (s *SQLiteStore) GetTagByName(ctx context.Context, name string) (models.Tag, error) {
+	name = normalizeTagName(name)
+	row := s.db.QueryRowContext(ctx, "SELECT "+tagColumns+" FROM tags t WHERE nameKey=?", tagKey(name))
 
 	var tag models.Tag
-	err := row.Scan(&tag.ID, &tag.Name, &tag.CreatedAt)
-	if err != nil {
-		fmt.Printf("getTag: Unable to Scan row. %v\n", err)
+	err := row.Scan(tagFields(&tag)...)
+	switch err {
+	case sql.ErrNoRows:
+		return tag, fmt.Errorf("tag %q: %w", name, ErrNotFound)
+	case nil:
+		return tag, nil
+	default:
+		return tag, fmt.Errorf("getTagByName: unable to scan the row: %w", err)
 	}
-	return tag, err
 }
 
-func deleteTag(id int64) (int64, error) {
-	db := createConnection()
-	defer db.Close()
+// GetTag returns a single tag
+func (s *SQLiteStore) GetTag(ctx context.Context, id int64) (models.Tag, error) {
+	return getTag(ctx, s.db, id)
+}
 
-	statement, err := db.Prepare("DELETE FROM tags WHERE id=?")
-	checkErr(err)
+func getTag(ctx context.Context, q querier, id int64) (models.Tag, error) {
+	row := q.QueryRowContext(ctx, "SELECT "+tagColumns+" FROM tags t WHERE id=?", id)
 
-	response, err := statement.Exec(id)
-	if err != nil {
-		log.Fatalf("deleteTag: Unable to execute the query. %v\n", err)
+	var tag models.Tag
+	err := row.Scan(tagFields(&tag)...)
+	switch err {
+	case sql.ErrNoRows:
+		return tag, fmt.Errorf("tag %v: %w", id, ErrNotFound)
+	case nil:
+		return tag, nil
+	default:
+		return tag, fmt.Errorf("getTag: unable to scan the row: %w", err)
 	}
+}
 
-	rowsAffected, err := response.RowsAffected()
+// UpdateTag overwrites the name, color and description of a tag. Renaming a
+// tag to the name of another one is a conflict.
+func (s *SQLiteStore) UpdateTag(ctx context.Context, id int64, tag models.Tag) (models.Tag, error) {
+	name, color, description := normalizeTagName(tag.Name), tag.Color, tag.Description
+	return s.PatchTag(ctx, id, TagPatch{Name: &name, Color: &color, Description: &description})
+}
+
+// PatchTag writes the fields set in patch and bumps updatedAt. Renaming a
+// tag renames the tags below it too, so team/backend follows team.
+func (s *SQLiteStore) PatchTag(ctx context.Context, id int64, patch TagPatch) (models.Tag, error) {
+	if patch.IsEmpty() {
+		return s.GetTag(ctx, id)
+	}
+
+	var tag models.Tag
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		current, err := getTag(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if patch.Precondition != nil {
+			if err := patch.Precondition(current); err != nil {
+				return err
+			}
+		}
 
+		var sets []string
+		var args []interface{}
+		if patch.Name != nil {
+			sets = append(sets, "name=?", "nameKey=?")
+			args = append(args, normalizeTagName(*patch.Name), tagKey(*patch.Name))
+		}
+		if patch.Color != nil {
+			sets = append(sets, "color=?")
+			args = append(args, *patch.Color)
+		}
+		if patch.Description != nil {
+			sets = append(sets, "description=?")
+			args = append(args, *patch.Description)
+		}
+		sets = append(sets, "updatedAt=strftime('%s', 'now')")
+		args = append(args, id)
+
+		if _, err := tx.ExecContext(ctx, "UPDATE tags SET "+strings.Join(sets, ", ")+" WHERE id=?", args...); err != nil {
+			return storeError(err)
+		}
+
+		if name := normalizeTagName(valueOr(patch.Name, current.Name)); name != current.Name {
+			// substr counts characters, so the prefix is measured by SQLite
+			// too; the tag itself is skipped as its new name may already
+			// sit below the old one, grp renamed to grp/x
+			// lower case keeps the number of characters, so the key of a
+			// child loses the same prefix as its name
+			oldKey := tagKey(current.Name)
+			_, err := tx.ExecContext(ctx, `UPDATE tags SET name = ? || substr(name, length(?) + 1), nameKey = ? || substr(nameKey, length(?) + 1),
+				updatedAt=strftime('%s', 'now') WHERE nameKey LIKE ? ESCAPE '\' AND id <> ?`,
+				name, current.Name, tagKey(name), oldKey, likeEscaper.Replace(oldKey)+tagSeparator+"%", id)
+			if err != nil {
+				return storeError(err)
+			}
+		}
+
+		tag, err = getTag(ctx, tx, id)
+		return err
+	})
+	return tag, err
+}
+
+// valueOr returns *p, or fallback when p is nil
+func valueOr(p *string, fallback string) string {
+	if p == nil {
+		return fallback
+	}
+	return *p
+}
+
+// SubtreeTags returns a tag and the tags below it ordered by name. A
+// positive depth stops that many levels below the tag.
+func (s *SQLiteStore) SubtreeTags(ctx context.Context, id int64, depth int) ([]models.Tag, error) {
+	root, err := s.GetTag(ctx, id)
 	if err != nil {
-		log.Fatalf("Error while checking the affected rows. %v\n", err)
+		return nil, err
 	}
 
-	fmt.Printf("Total rows/record affected %v\n", rowsAffected)
+	cond, args := tagSubtree("t.nameKey", tagKey(root.Name))
+	if depth > 0 {
+		cond += " AND length(t.name) - length(replace(t.name, '/', '')) <= ?"
+		args = append(args, strings.Count(root.Name, tagSeparator)+depth)
+	}
 
-	return rowsAffected, err
+	rows, err := s.db.QueryContext(ctx, "SELECT "+tagColumns+" FROM tags t WHERE "+cond+" ORDER BY t.name COLLATE NOCASE", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []models.Tag
+	for rows.Next() {
+		var tag models.Tag
+		if err := rows.Scan(tagFields(&tag)...); err != nil {
+			return nil, fmt.Errorf("subtreeTags: unable to scan the row: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
 }
 
-func associateTag(tagID int64, todoID int64) (int64, error) {
-	db := createConnection()
-	defer db.Close()
+// DeleteTag deletes a tag and returns the number of affected rows
+func (s *SQLiteStore) DeleteTag(ctx context.Context, id int64) (int64, error) {
+	response, err := s.db.ExecContext(ctx, "DELETE FROM tags WHERE id=?", id)
+	if err != nil {
+		return 0, fmt.Errorf("deleteTag: unable to execute the query: %w", err)
+	}
 
-	statement, err := db.Prepare("INSERT INTO todos_tags (tag_id, todo_id) VALUES (?, ?)")
-	checkErr(err)
+	rowsAffected, err := response.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
 
-	response, err := statement.Exec(tagID, todoID)
-	checkErr(err)
+	if rowsAffected == 0 {
+		return 0, fmt.Errorf("tag %v: %w", id, ErrNotFound)
+	}
 
-	id, err := response.LastInsertId()
+	return rowsAffected, nil
+}
+
+// AssociateTag links a tag to a todo and returns the id of the association,
+// ErrConflict when they are already linked
+func (s *SQLiteStore) AssociateTag(ctx context.Context, tagID int64, todoID int64) (int64, error) {
+	response, err := s.db.ExecContext(ctx, "INSERT INTO todos_tags (tag_id, todo_id) VALUES (?, ?)", tagID, todoID)
+	if isForeignKeyError(err) {
+		return 0, missingTagOrTodo(ctx, s.db, tagID, todoID)
+	}
 	if err != nil {
-		log.Fatalf("Unable to associate tag to todo. %v\n", err)
+		return 0, storeError(err)
 	}
 
 	// return the inserted id
-	return id, err
+	return response.LastInsertId()
+}
+
+// missingTagOrTodo reports which side of a rejected association does not exist
+func missingTagOrTodo(ctx context.Context, q querier, tagID, todoID int64) error {
+	var tagExists, todoExists bool
+	err := q.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM tags WHERE id=?), EXISTS (SELECT 1 FROM todos WHERE id=?)", tagID, todoID).Scan(&tagExists, &todoExists)
+	switch {
+	case err != nil:
+		return err
+	case !tagExists:
+		return fmt.Errorf("tag %v: %w", tagID, ErrNotFound)
+	case !todoExists:
+		return fmt.Errorf("todo %v: %w", todoID, ErrNotFound)
+	}
+	return fmt.Errorf("%w: tag %v cannot be associated with todo %v", ErrConflict, tagID, todoID)
 }
 
-func getAllTags() ([]models.Tag, error) {
-	db := createConnection()
-	defer db.Close()
+// DissociateTag removes a tag from a todo
+func (s *SQLiteStore) DissociateTag(ctx context.Context, tagID int64, todoID int64) error {
+	response, err := s.db.ExecContext(ctx, "DELETE FROM todos_tags WHERE tag_id=? AND todo_id=?", tagID, todoID)
+	if err != nil {
+		return err
+	}
 
-	rows, err := db.Query("SELECT * FROM tags")
-	checkErr(err)
+	rowsAffected, err := response.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("tag %v is not associated with todo %v: %w", tagID, todoID, ErrNotFound)
+	}
+	return nil
+}
 
+// ReplaceTags atomically sets the tags of a todo to exactly those named by
+// refs and returns them. Unknown tags are rejected with ErrInvalid.
+func (s *SQLiteStore) ReplaceTags(ctx context.Context, todoID int64, refs TagRefs) ([]models.Tag, error) {
 	var tags []models.Tag
-	var tag models.Tag
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM todos WHERE id=?", todoID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("todo %v: %w", todoID, ErrNotFound)
+		}
+
+		tagIDs, err := resolveTagRefs(ctx, tx, refs)
+		if err != nil {
+			return err
+		}
+
+		// tags kept on the todo keep their association, and when they were added
+		args := []interface{}{todoID}
+		for _, tagID := range tagIDs {
+			args = append(args, tagID)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM todos_tags WHERE todo_id=? AND tag_id NOT IN ("+placeholders(len(tagIDs))+")", args...); err != nil {
+			return err
+		}
+		for _, tagID := range tagIDs {
+			if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO todos_tags (tag_id, todo_id) VALUES (?, ?)", tagID, todoID); err != nil {
+				return storeError(err)
+			}
+		}
+
+		tags, err = tagsOfTodo(ctx, tx, todoID)
+		return err
+	})
+	return tags, err
+}
+
+// MergeTags moves the todos of the source tags onto the target tag and
+// deletes the sources, all in one transaction. Todos already carrying the
+// target keep a single association.
+func (s *SQLiteStore) MergeTags(ctx context.Context, targetID int64, sources TagRefs) (TagMerge, error) {
+	var merge TagMerge
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := getTag(ctx, tx, targetID); err != nil {
+			return err
+		}
+
+		sourceIDs, err := resolveTagRefs(ctx, tx, sources)
+		if err != nil {
+			return err
+		}
+		if len(sourceIDs) == 0 {
+			return invalidf("no tags to merge")
+		}
+
+		args := []interface{}{targetID}
+		for _, id := range sourceIDs {
+			if id == targetID {
+				return invalidf("tag %v cannot be merged into itself", id)
+			}
+			args = append(args, id)
+		}
+		in := "(" + placeholders(len(sourceIDs)) + ")"
+
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(DISTINCT todo_id) FROM todos_tags WHERE tag_id IN "+in, args[1:]...).Scan(&merge.AffectedTodos); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO todos_tags (tag_id, todo_id, taggedAt) SELECT ?, todo_id, taggedAt FROM todos_tags WHERE tag_id IN "+in, args...); err != nil {
+			return err
+		}
+		// the associations of the sources go with them
+		if _, err := tx.ExecContext(ctx, "DELETE FROM tags WHERE id IN "+in, args[1:]...); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE tags SET updatedAt=strftime('%s', 'now') WHERE id=?", targetID); err != nil {
+			return err
+		}
+
+		merge.MergedTags = sourceIDs
+		merge.Tag, err = getTag(ctx, tx, targetID)
+		return err
+	})
+	return merge, err
+}
+
+// resolveTagRefs turns tag ids and names into distinct tag ids
+func resolveTagRefs(ctx context.Context, q querier, refs TagRefs) ([]int64, error) {
+	seen := map[int64]bool{}
+	var ids []int64
+	var missing []string
+	add := func(id int64) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	for _, id := range refs.IDs {
+		var exists bool
+		if err := q.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM tags WHERE id=?", id).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, fmt.Sprint(id))
+			continue
+		}
+		add(id)
+	}
+
+	for _, name := range refs.Names {
+		var id int64
+		err := q.QueryRowContext(ctx, "SELECT id FROM tags WHERE nameKey=?", tagKey(name)).Scan(&id)
+		switch {
+		case err == sql.ErrNoRows:
+			missing = append(missing, fmt.Sprintf("%q", name))
+		case err != nil:
+			return nil, err
+		default:
+			add(id)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, invalidf("unknown tags: %s", strings.Join(missing, ", "))
+	}
+	return ids, nil
+}
+
+// GetAllTags returns one page of tags ordered by id
+func (s *SQLiteStore) GetAllTags(ctx context.Context, page Page) ([]models.TagUsage, string, error) {
+	after, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// fetch one extra row to learn whether there is a next page
+	limit := page.limit()
+	rows, err := s.db.QueryContext(ctx, "SELECT "+tagUsageColumns+" FROM tags t LEFT JOIN todos_tags jt ON jt.tag_id = t.id WHERE t.id > ? GROUP BY t.id ORDER BY t.id LIMIT ?", after.ID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	tags := []models.TagUsage{}
 	for rows.Next() {
-		if err := rows.Scan(&tag.ID, &tag.Name, &tag.CreatedAt); err != nil {
-			log.Fatalf("getAllTags: Unable to scan the row. %v\n", err)
+		tag, err := scanTagUsage(rows)
+		if err != nil {
+			return nil, "", fmt.Errorf("getAllTags: unable to scan the row: %w", err)
 		}
 		tags = append(tags, tag)
 	}
-	return tags, err
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(tags) > limit {
+		tags = tags[:limit]
+		next = encodeCursor(cursor{ID: tags[limit-1].ID})
+	}
+	return tags, next, nil
 }
 
-func checkErr(err error) {
+// tagUsageColumns extends tagColumns with the usage statistics of a tag,
+// for tags t left joined with todos_tags jt and grouped by t.id
+const tagUsageColumns = tagColumns + ", COUNT(jt.id), MAX(jt.taggedAt)"
+
+func scanTagUsage(rows *sql.Rows) (models.TagUsage, error) {
+	var tag models.TagUsage
+	var lastUsed sql.NullInt64
+	if err := rows.Scan(append(tagFields(&tag.Tag), &tag.UsageCount, &lastUsed)...); err != nil {
+		return tag, err
+	}
+	if lastUsed.Valid {
+		tag.LastUsedAt = time.Unix(lastUsed.Int64, 0).UTC().Format(time.RFC3339)
+	}
+	return tag, nil
+}
+
+// SuggestTags returns up to limit tags whose name, or one of its namespace
+// segments, starts with prefix. The most used tags come first, ties go to
+// the most recently used.
+func (s *SQLiteStore) SuggestTags(ctx context.Context, prefix string, limit int) ([]models.TagUsage, error) {
+	pattern := likeEscaper.Replace(tagKey(prefix)) + "%"
+	rows, err := s.db.QueryContext(ctx, "SELECT "+tagUsageColumns+` FROM tags t LEFT JOIN todos_tags jt ON jt.tag_id = t.id
+		WHERE t.nameKey LIKE ? ESCAPE '\' OR t.nameKey LIKE ? ESCAPE '\'
+		GROUP BY t.id ORDER BY COUNT(jt.id) DESC, MAX(jt.taggedAt) DESC, t.name COLLATE NOCASE LIMIT ?`,
+		pattern, "%"+tagSeparator+pattern, limit)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []models.TagUsage{}
+	for rows.Next() {
+		tag, err := scanTagUsage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("suggestTags: unable to scan the row: %w", err)
+		}
+		tags = append(tags, tag)
 	}
+	return tags, rows.Err()
 }
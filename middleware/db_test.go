@@ -1,15 +1,44 @@
 package middleware
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go-todo/models"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
-func TestConnection(t *testing.T) {
-	db := createConnection()
-	defer db.Close()
+var (
+	testStore *SQLiteStore
+	ctx       = context.Background()
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "go-todo")
+	if err != nil {
+		panic(err)
+	}
+
+	testStore, err = OpenSQLiteStore(filepath.Join(dir, "todo.db"))
+	if err != nil {
+		panic(err)
+	}
 
-	if err := db.Ping(); err != nil {
+	code := m.Run()
+
+	testStore.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestConnection(t *testing.T) {
+	if err := testStore.db.Ping(); err != nil {
 		t.Error("Cannot connect to database", err)
 	}
 }
@@ -20,7 +49,7 @@ func TestDBInsertTodo(t *testing.T) {
 	todo.Description = "Some description"
 	todo.Status = models.Open
 
-	newEntry, err := insertTodo(todo)
+	newEntry, err := testStore.InsertTodo(ctx, todo)
 	if err != nil {
 		t.Error("Error in adding new Todo", err)
 	}
@@ -43,12 +72,12 @@ func TestDBGetTodo(t *testing.T) {
 	todo.Description = "Some description"
 	todo.Status = 0
 
-	newEntry, err := insertTodo(todo)
+	newEntry, err := testStore.InsertTodo(ctx, todo)
 	if err != nil {
 		t.Error("Error in adding new Todo", err)
 	}
 
-	retrievedTodo, err := getTodo(int64(newEntry.ID))
+	retrievedTodo, err := testStore.GetTodo(ctx, newEntry.ID)
 
 	if err != nil {
 		t.Error("Error in retrieving Todo", err)
@@ -72,7 +101,7 @@ func TestDBGetTodo(t *testing.T) {
 }
 
 func TestDBGetAllTodos(t *testing.T) {
-	todos, err := getAllTodos()
+	todos, _, err := testStore.GetAllTodos(ctx, TodoFilter{}, Page{})
 	if err != nil {
 		t.Error("Error fetching todos", err)
 	}
@@ -82,7 +111,7 @@ func TestDBGetAllTodos(t *testing.T) {
 }
 
 func TestDBUpdateTodo(t *testing.T) {
-	todos, err := getAllTodos()
+	todos, _, err := testStore.GetAllTodos(ctx, TodoFilter{}, Page{})
 	if err != nil {
 		t.Error("Error fetching todos", err)
 	}
@@ -90,7 +119,7 @@ func TestDBUpdateTodo(t *testing.T) {
 
 	todo.Status = models.InProgress
 
-	updatedEntry, err := updateTodo(todo.ID, todo)
+	updatedEntry, err := testStore.UpdateTodo(ctx, todo.ID, todo, false)
 	if err != nil {
 		t.Error("Error updating todo", err)
 	}
@@ -101,28 +130,56 @@ func TestDBUpdateTodo(t *testing.T) {
 }
 
 func TestDBDeleteTodo(t *testing.T) {
-	todos, err := getAllTodos()
+	todos, _, err := testStore.GetAllTodos(ctx, TodoFilter{}, Page{})
 	if err != nil {
 		t.Error("Error fetching todos", err)
 	}
 	todo := todos[0]
 
-	_, err = deleteTodo(todo.ID)
+	_, err = testStore.DeleteTodo(ctx, todo.ID)
 	if err != nil {
 		t.Error("Todo was not deleted", err)
 	}
 
-	response, _ := getTodo(todo.ID)
+	response, err := testStore.GetTodo(ctx, todo.ID)
+
+	if !errors.Is(err, ErrNotFound) {
+		t.Error("Was able to fetch the original todo", response, todo, err)
+	}
+}
+
+func TestDBMissingTodo(t *testing.T) {
+	const missing = 999999
+
+	if _, err := testStore.GetTodo(ctx, missing); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound fetching a missing todo", err)
+	}
+
+	if _, err := testStore.UpdateTodo(ctx, missing, models.ToDo{Title: "ghost"}, false); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound updating a missing todo", err)
+	}
+
+	if _, err := testStore.DeleteTodo(ctx, missing); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound deleting a missing todo", err)
+	}
+}
+
+func TestDBMissingTag(t *testing.T) {
+	const missing = 999999
 
-	if !response.IsEmpty() {
-		t.Error("Was able to fetch the original todo", response, todo)
+	if _, err := testStore.GetTag(ctx, missing); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound fetching a missing tag", err)
+	}
+
+	if _, err := testStore.DeleteTag(ctx, missing); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound deleting a missing tag", err)
 	}
 }
 
 func TestDBinsertTag(t *testing.T) {
 	var tag models.Tag
 	tag.Name = "testing tag"
-	newEntry, err := insertTag(tag)
+	newEntry, err := testStore.InsertTag(ctx, tag)
 	if err != nil {
 		t.Error("Error inserting tag", err)
 	}
@@ -132,15 +189,17 @@ func TestDBinsertTag(t *testing.T) {
 }
 
 func TestDBDeleteTag(t *testing.T) {
-	tags, _ := getAllTags()
-	tag := tags[0]
-	if _, err := deleteTag(tag.ID); err != nil {
+	tag, err := testStore.InsertTag(ctx, models.Tag{Name: "to be deleted"})
+	if err != nil {
+		t.Error("Error inserting tag", err)
+	}
+	if _, err := testStore.DeleteTag(ctx, tag.ID); err != nil {
 		t.Error("Error deleting tag", err)
 	}
 }
 
 func TestDBGetAllTags(t *testing.T) {
-	tags, err := getAllTags()
+	tags, _, err := testStore.GetAllTags(ctx, Page{})
 	if err != nil {
 		t.Error("Error in fetching all tags", err)
 	}
@@ -150,7 +209,7 @@ func TestDBGetAllTags(t *testing.T) {
 }
 
 func TestDBAssociateTagWithTodo(t *testing.T) {
-	todos, err := getAllTodos()
+	todos, _, err := testStore.GetAllTodos(ctx, TodoFilter{}, Page{})
 	if err != nil {
 		t.Error("Error in fetching all todos", err)
 	}
@@ -159,16 +218,16 @@ func TestDBAssociateTagWithTodo(t *testing.T) {
 
 	var newTag models.Tag
 	newTag.Name = "association"
-	response, err := insertTag(newTag)
+	response, err := testStore.InsertTag(ctx, newTag)
 	if err != nil {
 		t.Error("Error creating new Tag", err)
 	}
 
-	if _, err = associateTag(response.ID, todo.ID); err != nil {
+	if _, err = testStore.AssociateTag(ctx, response.ID, todo.ID); err != nil {
 		t.Error("Error associating tag", err)
 	}
 
-	updatedTodo, err := getTodo(todo.ID)
+	updatedTodo, err := testStore.GetTodo(ctx, todo.ID)
 	if err != nil {
 		t.Error("Error retrieving updated todo", err)
 	}
@@ -186,3 +245,1505 @@ func TestDBAssociateTagWithTodo(t *testing.T) {
 	}
 
 }
+
+func TestDBPaginateTodos(t *testing.T) {
+	for i := 0; i < 5; i++ {
+		if _, err := testStore.InsertTodo(ctx, models.ToDo{Title: "paginated"}); err != nil {
+			t.Fatal("Error in adding new Todo", err)
+		}
+	}
+
+	seen := map[int64]bool{}
+	page := Page{Limit: 2}
+	for pages := 0; ; pages++ {
+		todos, next, err := testStore.GetAllTodos(ctx, TodoFilter{}, page)
+		if err != nil {
+			t.Fatal("Error fetching page", err)
+		}
+		if len(todos) > 2 {
+			t.Error("Page exceeds the limit", len(todos))
+		}
+		for _, todo := range todos {
+			if seen[todo.ID] {
+				t.Error("Todo returned twice", todo.ID)
+			}
+			seen[todo.ID] = true
+		}
+
+		// rows deleted behind the cursor must not shift the following pages
+		if pages == 0 && len(todos) > 0 {
+			if _, err := testStore.DeleteTodo(ctx, todos[0].ID); err != nil {
+				t.Error("Error deleting todo", err)
+			}
+		}
+
+		if next == "" {
+			break
+		}
+		page.Cursor = next
+	}
+
+	all, _, err := testStore.GetAllTodos(ctx, TodoFilter{}, Page{Limit: MaxPageLimit})
+	if err != nil {
+		t.Fatal("Error fetching todos", err)
+	}
+	for _, todo := range all {
+		if !seen[todo.ID] {
+			t.Error("Todo skipped while paging", todo.ID)
+		}
+	}
+}
+
+func TestDBMalformedCursor(t *testing.T) {
+	if _, _, err := testStore.GetAllTags(ctx, Page{Cursor: "not a cursor"}); err == nil {
+		t.Error("Expected an error for a malformed cursor")
+	}
+}
+
+func TestDBFilterTodos(t *testing.T) {
+	red, err := testStore.InsertTag(ctx, models.Tag{Name: "filter red"})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	blue, err := testStore.InsertTag(ctx, models.Tag{Name: "filter blue"})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+
+	insert := func(title string, status models.ToDoStatus, tags ...models.Tag) models.ToDo {
+		todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: title})
+		if err != nil {
+			t.Fatal("Error in adding new Todo", err)
+		}
+		todo.Status = status
+		if todo, err = testStore.UpdateTodo(ctx, todo.ID, todo, false); err != nil {
+			t.Fatal("Error updating todo", err)
+		}
+		for _, tag := range tags {
+			if _, err := testStore.AssociateTag(ctx, tag.ID, todo.ID); err != nil {
+				t.Fatal("Error associating tag", err)
+			}
+		}
+		return todo
+	}
+	a := insert("filtered a_1", models.Open, red)
+	b := insert("filtered b_2", models.InProgress, red, blue)
+	c := insert("filtered c_3", models.Closed, blue)
+
+	ids := func(filter TodoFilter) []int64 {
+		t.Helper()
+		if filter.Title == "" {
+			filter.Title = "filtered"
+		}
+		todos, _, err := testStore.GetAllTodos(ctx, filter, Page{})
+		if err != nil {
+			t.Fatal("Error fetching todos", err)
+		}
+		var ids []int64
+		for _, todo := range todos {
+			ids = append(ids, todo.ID)
+		}
+		return ids
+	}
+	expect := func(name string, got []int64, want ...models.ToDo) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Error(name, "returned", got)
+			return
+		}
+		for i := range want {
+			if got[i] != want[i].ID {
+				t.Error(name, "returned", got)
+				return
+			}
+		}
+	}
+
+	expect("no filter", ids(TodoFilter{}), a, b, c)
+	expect("status", ids(TodoFilter{Statuses: []models.ToDoStatus{models.Open, models.Closed}}), a, c)
+	expect("any tag", ids(TodoFilter{Tags: []string{"filter red", "filter blue"}}), a, b, c)
+	expect("all tags", ids(TodoFilter{Tags: []string{"filter red", "filter blue"}, TagMode: TagsAll}), b)
+	expect("title substring", ids(TodoFilter{Title: "d a_"}), a)
+	expect("future createdAt", ids(TodoFilter{CreatedFrom: time.Now().Add(time.Hour)}))
+	expect("sort by status desc", ids(TodoFilter{Sort: "status", Desc: true}), c, b, a)
+
+	// page through a non-id sort one row at a time
+	var paged []int64
+	page := Page{Limit: 1}
+	filter := TodoFilter{Title: "filtered", Sort: "title", Desc: true}
+	for {
+		todos, next, err := testStore.GetAllTodos(ctx, filter, page)
+		if err != nil {
+			t.Fatal("Error fetching page", err)
+		}
+		for _, todo := range todos {
+			paged = append(paged, todo.ID)
+		}
+		if next == "" {
+			break
+		}
+		page.Cursor = next
+	}
+	expect("paged title desc", paged, c, b, a)
+
+	// a cursor only continues the sort it was issued for
+	_, next, _ := testStore.GetAllTodos(ctx, filter, Page{Limit: 1})
+	if _, _, err := testStore.GetAllTodos(ctx, TodoFilter{Sort: "createdAt"}, Page{Cursor: next}); err == nil {
+		t.Error("Expected an error reusing a cursor with another sort")
+	}
+}
+
+func TestDBSearchTodos(t *testing.T) {
+	if !testStore.search {
+		if _, err := testStore.SearchTodos(ctx, "anything", 10); !errors.Is(err, ErrUnavailable) {
+			t.Error("Expected ErrUnavailable without a search index", err)
+		}
+		t.Skip("sqlite built without FTS5, run the tests with -tags sqlite_fts5")
+	}
+
+	water, err := testStore.InsertTodo(ctx, models.ToDo{Title: "Water the plants", Description: "The ferns in the hallway need watering twice a week"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	fern, err := testStore.InsertTodo(ctx, models.ToDo{Title: "Repot", Description: "Move the fern into a bigger pot"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+
+	results, err := testStore.SearchTodos(ctx, "fern*", 10)
+	if err != nil {
+		t.Fatal("Error searching todos", err)
+	}
+	if len(results) != 2 {
+		t.Fatal("Prefix query did not match both todos", results)
+	}
+
+	results, err = testStore.SearchTodos(ctx, "water OR fern", 10)
+	if err != nil {
+		t.Fatal("Error searching todos", err)
+	}
+	if len(results) == 0 || results[0].ID != water.ID {
+		t.Error("Title match is not ranked first", results)
+	}
+	if results[0].TitleHighlight != "<mark>Water</mark> the plants" {
+		t.Error("Unexpected title highlight", results[0].TitleHighlight)
+	}
+
+	results, err = testStore.SearchTodos(ctx, `"bigger pot"`, 10)
+	if err != nil {
+		t.Fatal("Error searching todos", err)
+	}
+	if len(results) != 1 || results[0].ID != fern.ID {
+		t.Error("Phrase query did not match", results)
+	}
+
+	// the index follows updates and deletes
+	fern.Title, fern.Description = "Repot", "Move the cactus"
+	if _, err := testStore.UpdateTodo(ctx, fern.ID, fern, false); err != nil {
+		t.Fatal("Error updating todo", err)
+	}
+	if _, err := testStore.DeleteTodo(ctx, water.ID); err != nil {
+		t.Fatal("Error deleting todo", err)
+	}
+	if results, _ = testStore.SearchTodos(ctx, "fern*", 10); len(results) != 0 {
+		t.Error("Index is out of sync with the todos table", results)
+	}
+
+	var badRequest errBadRequest
+	if _, err := testStore.SearchTodos(ctx, `"unterminated`, 10); !errors.As(err, &badRequest) {
+		t.Error("Expected a bad request for a malformed query", err)
+	}
+}
+
+func TestDBListIncludesTags(t *testing.T) {
+	tag, err := testStore.InsertTag(ctx, models.Tag{Name: "listed"})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	tagged, err := testStore.InsertTodo(ctx, models.ToDo{Title: "tags in list"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	bare, err := testStore.InsertTodo(ctx, models.ToDo{Title: "tags in list"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	if _, err := testStore.AssociateTag(ctx, tag.ID, tagged.ID); err != nil {
+		t.Fatal("Error associating tag", err)
+	}
+
+	todos, _, err := testStore.GetAllTodos(ctx, TodoFilter{Title: "tags in list"}, Page{})
+	if err != nil {
+		t.Fatal("Error fetching todos", err)
+	}
+	if len(todos) != 2 {
+		t.Fatal("Unexpected todos", todos)
+	}
+	if len(todos[0].Tags) != 1 || todos[0].Tags[0].Name != "listed" {
+		t.Error("Tagged todo is missing its tags", todos[0])
+	}
+	if len(todos[1].Tags) != 0 || todos[1].ID != bare.ID {
+		t.Error("Untagged todo carries tags", todos[1])
+	}
+
+	todos, _, err = testStore.GetAllTodos(ctx, TodoFilter{Title: "tags in list", WithoutTags: true}, Page{})
+	if err != nil {
+		t.Fatal("Error fetching todos", err)
+	}
+	if len(todos[0].Tags) != 0 {
+		t.Error("Tags were loaded despite WithoutTags", todos[0])
+	}
+}
+
+func TestDBDissociateTag(t *testing.T) {
+	tag, err := testStore.InsertTag(ctx, models.Tag{Name: "dissociated"})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "dissociate"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	if _, err := testStore.AssociateTag(ctx, tag.ID, todo.ID); err != nil {
+		t.Fatal("Error associating tag", err)
+	}
+	if _, err := testStore.AssociateTag(ctx, tag.ID, todo.ID); !errors.Is(err, ErrConflict) {
+		t.Error("Expected ErrConflict for a duplicate association, got", err)
+	}
+
+	if err := testStore.DissociateTag(ctx, tag.ID, todo.ID); err != nil {
+		t.Fatal("Error dissociating tag", err)
+	}
+	if tags, _ := testStore.GetTagsOfTodo(ctx, todo.ID); len(tags) != 0 {
+		t.Error("Tag is still associated", tags)
+	}
+	if err := testStore.DissociateTag(ctx, tag.ID, todo.ID); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound for a missing association, got", err)
+	}
+}
+
+func TestDBReplaceTags(t *testing.T) {
+	var tags []models.Tag
+	for _, name := range []string{"replace a", "replace b", "replace c"} {
+		tag, err := testStore.InsertTag(ctx, models.Tag{Name: name})
+		if err != nil {
+			t.Fatal("Error inserting tag", err)
+		}
+		tags = append(tags, tag)
+	}
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "replace tags"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	if _, err := testStore.AssociateTag(ctx, tags[0].ID, todo.ID); err != nil {
+		t.Fatal("Error associating tag", err)
+	}
+
+	got, err := testStore.ReplaceTags(ctx, todo.ID, TagRefs{IDs: []int64{tags[1].ID}, Names: []string{"replace c", "replace b"}})
+	if err != nil {
+		t.Fatal("Error replacing tags", err)
+	}
+	if len(got) != 2 || got[0].ID != tags[1].ID || got[1].ID != tags[2].ID {
+		t.Error("Unexpected tags after replace", got)
+	}
+
+	// an unknown tag leaves the set untouched
+	_, err = testStore.ReplaceTags(ctx, todo.ID, TagRefs{Names: []string{"replace a", "no such tag"}})
+	if !errors.Is(err, ErrInvalid) {
+		t.Error("Expected ErrInvalid for an unknown tag, got", err)
+	}
+	if got, _ := testStore.GetTagsOfTodo(ctx, todo.ID); len(got) != 2 {
+		t.Error("Failed replace changed the tags", got)
+	}
+
+	if _, err := testStore.ReplaceTags(ctx, -1, TagRefs{}); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound for a missing todo, got", err)
+	}
+
+	got, err = testStore.ReplaceTags(ctx, todo.ID, TagRefs{})
+	if err != nil || len(got) != 0 {
+		t.Error("Empty replace did not clear the tags", got, err)
+	}
+}
+
+func TestDBDeleteCascadesToAssociations(t *testing.T) {
+	tag, err := testStore.InsertTag(ctx, models.Tag{Name: "cascade"})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "cascade"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	other, err := testStore.InsertTodo(ctx, models.ToDo{Title: "cascade"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	for _, id := range []int64{todo.ID, other.ID} {
+		if _, err := testStore.AssociateTag(ctx, tag.ID, id); err != nil {
+			t.Fatal("Error associating tag", err)
+		}
+	}
+
+	associations := func() int {
+		var n int
+		if err := testStore.db.QueryRow("SELECT COUNT(*) FROM todos_tags WHERE tag_id=?", tag.ID).Scan(&n); err != nil {
+			t.Fatal("Error counting associations", err)
+		}
+		return n
+	}
+
+	if _, err := testStore.DeleteTodo(ctx, todo.ID); err != nil {
+		t.Fatal("Error deleting todo", err)
+	}
+	if n := associations(); n != 1 {
+		t.Error("Deleting a todo left its associations behind", n)
+	}
+	if _, err := testStore.DeleteTag(ctx, tag.ID); err != nil {
+		t.Fatal("Error deleting tag", err)
+	}
+	if n := associations(); n != 0 {
+		t.Error("Deleting a tag left its associations behind", n)
+	}
+}
+
+func TestDBAssociateMissing(t *testing.T) {
+	tag, err := testStore.InsertTag(ctx, models.Tag{Name: "associate missing"})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "associate missing"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+
+	if _, err := testStore.AssociateTag(ctx, tag.ID, -1); !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "todo") {
+		t.Error("Expected a missing todo error, got", err)
+	}
+	if _, err := testStore.AssociateTag(ctx, -1, todo.ID); !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "tag") {
+		t.Error("Expected a missing tag error, got", err)
+	}
+}
+
+func TestDBTagNamesAreUnique(t *testing.T) {
+	tag, err := testStore.InsertTag(ctx, models.Tag{Name: "  Get Or Create "})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	if tag.Name != "Get Or Create" {
+		t.Error("Tag name was not trimmed", tag.Name)
+	}
+
+	again, err := testStore.InsertTag(ctx, models.Tag{Name: "get or create"})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	if again != tag {
+		t.Error("Inserting an existing name created a new tag", again, tag)
+	}
+
+	found, err := testStore.GetTagByName(ctx, "GET OR CREATE ")
+	if err != nil || found != tag {
+		t.Error("Lookup by name did not find the tag", found, err)
+	}
+	if _, err := testStore.GetTagByName(ctx, "no such tag name"); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound for an unknown name, got", err)
+	}
+}
+
+func TestDBUpdateTag(t *testing.T) {
+	tag, err := testStore.InsertTag(ctx, models.Tag{Name: "tpyo"})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "renamed tag"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	if _, err := testStore.AssociateTag(ctx, tag.ID, todo.ID); err != nil {
+		t.Fatal("Error associating tag", err)
+	}
+
+	updated, err := testStore.UpdateTag(ctx, tag.ID, models.Tag{Name: " typo ", Color: "#f80", Description: "fixed"})
+	if err != nil {
+		t.Fatal("Error updating tag", err)
+	}
+	if updated.Name != "typo" || updated.Color != "#f80" || updated.Description != "fixed" || updated.CreatedAt != tag.CreatedAt {
+		t.Error("Unexpected tag after update", updated)
+	}
+	if tags, _ := testStore.GetTagsOfTodo(ctx, todo.ID); len(tags) != 1 || tags[0].Name != "typo" {
+		t.Error("Associated todo does not see the new name", tags)
+	}
+
+	color := ""
+	patched, err := testStore.PatchTag(ctx, tag.ID, TagPatch{Color: &color})
+	if err != nil {
+		t.Fatal("Error patching tag", err)
+	}
+	if patched.Color != "" || patched.Description != "fixed" {
+		t.Error("Patch wrote more than the color", patched)
+	}
+
+	if _, err := testStore.InsertTag(ctx, models.Tag{Name: "taken"}); err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	if _, err := testStore.UpdateTag(ctx, tag.ID, models.Tag{Name: "TAKEN"}); !errors.Is(err, ErrConflict) {
+		t.Error("Expected ErrConflict renaming onto an existing name, got", err)
+	}
+	if _, err := testStore.UpdateTag(ctx, -1, models.Tag{Name: "missing"}); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound for a missing tag, got", err)
+	}
+}
+
+func TestDBMergeTags(t *testing.T) {
+	var tags []models.Tag
+	for _, name := range []string{"merge bug", "merge Bugs", "merge defect"} {
+		tag, err := testStore.InsertTag(ctx, models.Tag{Name: name})
+		if err != nil {
+			t.Fatal("Error inserting tag", err)
+		}
+		tags = append(tags, tag)
+	}
+	target := tags[0]
+
+	var todos []models.ToDo
+	for i := 0; i < 3; i++ {
+		todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "merge tags"})
+		if err != nil {
+			t.Fatal("Error in adding new Todo", err)
+		}
+		todos = append(todos, todo)
+	}
+	// the first todo carries the target and a source, the others one source each
+	for _, a := range []struct{ tag, todo int }{{0, 0}, {1, 0}, {1, 1}, {2, 2}} {
+		if _, err := testStore.AssociateTag(ctx, tags[a.tag].ID, todos[a.todo].ID); err != nil {
+			t.Fatal("Error associating tag", err)
+		}
+	}
+
+	merge, err := testStore.MergeTags(ctx, target.ID, TagRefs{IDs: []int64{tags[1].ID}, Names: []string{"merge defect"}})
+	if err != nil {
+		t.Fatal("Error merging tags", err)
+	}
+	if merge.AffectedTodos != 3 || len(merge.MergedTags) != 2 || merge.Tag.ID != target.ID {
+		t.Error("Unexpected merge report", merge)
+	}
+	for _, todo := range todos {
+		if got, _ := testStore.GetTagsOfTodo(ctx, todo.ID); len(got) != 1 || got[0].ID != target.ID {
+			t.Error("Todo does not carry exactly the target tag", todo.ID, got)
+		}
+	}
+	if _, err := testStore.GetTag(ctx, tags[1].ID); !errors.Is(err, ErrNotFound) {
+		t.Error("Source tag was not deleted", err)
+	}
+
+	if _, err := testStore.MergeTags(ctx, target.ID, TagRefs{IDs: []int64{target.ID}}); !errors.Is(err, ErrInvalid) {
+		t.Error("Expected ErrInvalid merging a tag into itself, got", err)
+	}
+	if _, err := testStore.MergeTags(ctx, -1, TagRefs{IDs: []int64{target.ID}}); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound for a missing target, got", err)
+	}
+}
+
+func TestDBTagNamespaces(t *testing.T) {
+	ids := map[string]int64{}
+	for _, name := range []string{"ns team", "ns team/backend", " ns team / backend/api ", "ns teams"} {
+		tag, err := testStore.InsertTag(ctx, models.Tag{Name: name})
+		if err != nil {
+			t.Fatal("Error inserting tag", err)
+		}
+		ids[tag.Name] = tag.ID
+	}
+	if _, ok := ids["ns team/backend/api"]; !ok {
+		t.Fatal("Namespace segments were not normalised", ids)
+	}
+
+	names := func(tags []models.Tag) string {
+		var names []string
+		for _, tag := range tags {
+			names = append(names, tag.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	subtree, err := testStore.SubtreeTags(ctx, ids["ns team"], 0)
+	if err != nil {
+		t.Fatal("Error listing subtree", err)
+	}
+	if got := names(subtree); got != "ns team,ns team/backend,ns team/backend/api" {
+		t.Error("Unexpected subtree", got)
+	}
+	subtree, _ = testStore.SubtreeTags(ctx, ids["ns team"], 1)
+	if got := names(subtree); got != "ns team,ns team/backend" {
+		t.Error("Unexpected subtree one level deep", got)
+	}
+
+	// filtering by the parent matches todos tagged with a descendant
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "namespaced"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	if _, err := testStore.AssociateTag(ctx, ids["ns team/backend/api"], todo.ID); err != nil {
+		t.Fatal("Error associating tag", err)
+	}
+	for _, tag := range []string{"ns team", "NS Team/Backend"} {
+		todos, _, err := testStore.GetAllTodos(ctx, TodoFilter{Tags: []string{tag}, TagMode: TagsAll}, Page{})
+		if err != nil {
+			t.Fatal("Error fetching todos", err)
+		}
+		if len(todos) != 1 || todos[0].ID != todo.ID {
+			t.Error("Parent tag filter did not match the descendant", tag, todos)
+		}
+	}
+	if todos, _, _ := testStore.GetAllTodos(ctx, TodoFilter{Tags: []string{"ns teams"}}, Page{}); len(todos) != 0 {
+		t.Error("Sibling with a common prefix matched", todos)
+	}
+
+	// renaming the parent carries its children
+	name := "ns squad"
+	if _, err := testStore.PatchTag(ctx, ids["ns team"], TagPatch{Name: &name}); err != nil {
+		t.Fatal("Error renaming tag", err)
+	}
+	subtree, _ = testStore.SubtreeTags(ctx, ids["ns team"], 0)
+	if got := names(subtree); got != "ns squad,ns squad/backend,ns squad/backend/api" {
+		t.Error("Children did not follow the renamed parent", got)
+	}
+	if tag, _ := testStore.GetTag(ctx, ids["ns teams"]); tag.Name != "ns teams" {
+		t.Error("Sibling with a common prefix was renamed", tag)
+	}
+}
+
+func TestDBTagNamesFoldUnicodeCase(t *testing.T) {
+	tag, err := testStore.InsertTag(ctx, models.Tag{Name: "Élan"})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	again, err := testStore.InsertTag(ctx, models.Tag{Name: " élan "})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	if again.ID != tag.ID || again.Name != "Élan" {
+		t.Error("A name differing in non-ASCII case made a new tag", tag, again)
+	}
+	if found, err := testStore.GetTagByName(ctx, "ÉLAN"); err != nil || found.ID != tag.ID {
+		t.Error("Tag not found by its upper case name", found, err)
+	}
+
+	other, err := testStore.InsertTag(ctx, models.Tag{Name: "élan rouge"})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	name := "ÉLAN"
+	if _, err := testStore.PatchTag(ctx, other.ID, TagPatch{Name: &name}); !errors.Is(err, ErrConflict) {
+		t.Error("Expected ErrConflict renaming to a name differing in case, got", err)
+	}
+
+	// children are matched by the folded name too
+	child, err := testStore.InsertTag(ctx, models.Tag{Name: "élan/front"})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	subtree, err := testStore.SubtreeTags(ctx, tag.ID, 0)
+	if err != nil {
+		t.Fatal("Error listing subtree", err)
+	}
+	if len(subtree) != 2 || subtree[1].ID != child.ID {
+		t.Error("Unexpected subtree", subtree)
+	}
+}
+
+func TestDBRenameTagNamespace(t *testing.T) {
+	rename := func(id int64, name string) {
+		t.Helper()
+		if _, err := testStore.PatchTag(ctx, id, TagPatch{Name: &name}); err != nil {
+			t.Fatal("Error renaming tag", err)
+		}
+	}
+	insert := func(name string) int64 {
+		t.Helper()
+		tag, err := testStore.InsertTag(ctx, models.Tag{Name: name})
+		if err != nil {
+			t.Fatal("Error inserting tag", err)
+		}
+		return tag.ID
+	}
+
+	// the prefix is cut by characters, not bytes
+	parent, child := insert("équipe"), insert("équipe/front")
+	rename(parent, "crew")
+	if tag, _ := testStore.GetTag(ctx, child); tag.Name != "crew/front" {
+		t.Error("Child of a non-ASCII tag was renamed wrongly", tag.Name)
+	}
+
+	// moving a tag below its old name renames it once
+	parent, child = insert("grp"), insert("grp/sub")
+	rename(parent, "grp/x")
+	if tag, _ := testStore.GetTag(ctx, parent); tag.Name != "grp/x" {
+		t.Error("Tag moved below its old name was renamed twice", tag.Name)
+	}
+	if tag, _ := testStore.GetTag(ctx, child); tag.Name != "grp/x/sub" {
+		t.Error("Child did not follow the moved tag", tag.Name)
+	}
+}
+
+func TestDBSuggestTags(t *testing.T) {
+	ids := map[string]int64{}
+	for _, name := range []string{"suggest rare", "suggest popular", "suggest unused", "area/suggest nested"} {
+		tag, err := testStore.InsertTag(ctx, models.Tag{Name: name})
+		if err != nil {
+			t.Fatal("Error inserting tag", err)
+		}
+		ids[name] = tag.ID
+	}
+	for i := 0; i < 3; i++ {
+		todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "suggest"})
+		if err != nil {
+			t.Fatal("Error in adding new Todo", err)
+		}
+		tagged := []string{"suggest popular"}
+		if i == 0 {
+			tagged = append(tagged, "suggest rare", "area/suggest nested")
+		}
+		for _, name := range tagged {
+			if _, err := testStore.AssociateTag(ctx, ids[name], todo.ID); err != nil {
+				t.Fatal("Error associating tag", err)
+			}
+		}
+	}
+
+	tags, err := testStore.SuggestTags(ctx, "SUGGEST", 10)
+	if err != nil {
+		t.Fatal("Error suggesting tags", err)
+	}
+	if len(tags) != 4 || tags[0].Name != "suggest popular" || tags[0].UsageCount != 3 {
+		t.Fatal("Most used tag is not suggested first", tags)
+	}
+	if last := tags[3]; last.Name != "suggest unused" || last.UsageCount != 0 || last.LastUsedAt != "" {
+		t.Error("Unused tag is not suggested last", last)
+	}
+	if tags[1].LastUsedAt == "" {
+		t.Error("Used tag has no last use", tags[1])
+	}
+
+	if tags, _ := testStore.SuggestTags(ctx, "suggest", 1); len(tags) != 1 {
+		t.Error("Limit was not applied", tags)
+	}
+	if tags, _ := testStore.SuggestTags(ctx, "suggest n", 10); len(tags) != 1 || tags[0].Name != "area/suggest nested" {
+		t.Error("Namespace segment did not match the prefix", tags)
+	}
+}
+
+func TestDBDueViews(t *testing.T) {
+	now := time.Date(2030, 3, 10, 12, 0, 0, 0, time.UTC)
+	ids := map[string]int64{}
+	for name, due := range map[string]string{
+		"yesterday":      "2030-03-09",
+		"this morning":   "2030-03-10T09:00:00Z",
+		"today":          "2030-03-10",
+		"tonight":        "2030-03-10T18:00:00+02:00",
+		"in two days":    "2030-03-12",
+		"in two weeks":   "2030-03-24",
+		"closed overdue": "2030-03-01",
+		"not due":        "",
+	} {
+		todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "due view", Description: name, DueAt: due})
+		if err != nil {
+			t.Fatal("Error in adding new Todo", err)
+		}
+		ids[name] = todo.ID
+	}
+	closed := models.Closed
+	if _, err := testStore.PatchTodo(ctx, ids["closed overdue"], TodoPatch{Status: &closed}); err != nil {
+		t.Fatal("Error closing todo", err)
+	}
+
+	view := func(name, target string) string {
+		filter := TodoFilter{Title: "due view"}
+		r := httptest.NewRequest("GET", target, nil)
+		if tz := r.URL.Query().Get("tz"); tz != "" {
+			filter.Location, _ = time.LoadLocation(tz)
+		}
+		if err := dueView(&filter, name, r, now); err != nil {
+			t.Fatal("Error building view", err)
+		}
+		todos, _, err := testStore.GetAllTodos(ctx, filter, Page{})
+		if err != nil {
+			t.Fatal("Error fetching todos", err)
+		}
+		var names []string
+		for _, todo := range todos {
+			names = append(names, todo.Description)
+		}
+		return strings.Join(names, ",")
+	}
+
+	for _, c := range []struct{ view, target, want string }{
+		{"overdue", "/", "yesterday,this morning"},
+		// dates sort at the start of their day
+		{"today", "/", "today,this morning,tonight"},
+		{"upcoming", "/", "today,this morning,tonight,in two days"},
+		{"upcoming", "/?days=1", "today,this morning,tonight"},
+		// it is already the 11th in Auckland
+		{"overdue", "/?tz=Pacific/Auckland", "yesterday,today,this morning"},
+	} {
+		if got := view(c.view, c.target); got != c.want {
+			t.Errorf("%s %s: got %q, want %q", c.view, c.target, got, c.want)
+		}
+	}
+
+	todo, _ := testStore.GetTodo(ctx, ids["tonight"])
+	if todo.DueAt != "2030-03-10T18:00:00+02:00" {
+		t.Error("Due time lost its offset", todo.DueAt)
+	}
+	if _, err := testStore.InsertTodo(ctx, models.ToDo{Title: "due view", DueAt: "2030-03-10T18:00:00"}); !errors.Is(err, ErrInvalid) {
+		t.Error("Expected ErrInvalid for a due time without a zone, got", err)
+	}
+}
+
+func TestDBDueSortZone(t *testing.T) {
+	for _, todo := range []models.ToDo{
+		{Description: "date", DueAt: "2030-06-20"},
+		{Description: "evening before", DueAt: "2030-06-19T20:00:00-07:00"},
+		{Description: "morning", DueAt: "2030-06-20T09:00:00-07:00"},
+	} {
+		todo.Title = "due sort zone"
+		if _, err := testStore.InsertTodo(ctx, todo); err != nil {
+			t.Fatal("Error in adding new Todo", err)
+		}
+	}
+	losAngeles, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip("time zone database unavailable", err)
+	}
+
+	// a page at a time, so the cursor uses the same key
+	sorted := func(loc *time.Location) string {
+		var names []string
+		page := Page{Limit: 1}
+		for {
+			todos, next, err := testStore.GetAllTodos(ctx, TodoFilter{Title: "due sort zone", Sort: "dueAt", Location: loc}, page)
+			if err != nil {
+				t.Fatal("Error fetching todos", err)
+			}
+			for _, todo := range todos {
+				names = append(names, todo.Description)
+			}
+			if next == "" {
+				return strings.Join(names, ",")
+			}
+			page.Cursor = next
+		}
+	}
+	if got := sorted(nil); got != "date,evening before,morning" {
+		t.Error("Unexpected order in UTC", got)
+	}
+	if got := sorted(losAngeles); got != "evening before,date,morning" {
+		t.Error("Unexpected order in Los Angeles", got)
+	}
+
+	// a cursor does not carry over to another time zone
+	_, next, _ := testStore.GetAllTodos(ctx, TodoFilter{Title: "due sort zone", Sort: "dueAt"}, Page{Limit: 1})
+	if _, _, err := testStore.GetAllTodos(ctx, TodoFilter{Title: "due sort zone", Sort: "dueAt", Location: losAngeles}, Page{Limit: 1, Cursor: next}); err == nil {
+		t.Error("Expected an error for a cursor of another time zone")
+	}
+}
+
+func TestDBImportanceUsesIndex(t *testing.T) {
+	for _, where := range []string{"", "WHERE (" + todoImportance + ", id) > (0, 0) "} {
+		rows, err := testStore.db.QueryContext(ctx, "EXPLAIN QUERY PLAN SELECT id FROM todos "+where+"ORDER BY "+todoImportance+" ASC, id ASC LIMIT 10")
+		if err != nil {
+			t.Fatal("Error explaining query", err)
+		}
+		var plan []string
+		for rows.Next() {
+			var id, parent, unused int
+			var detail string
+			if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+				t.Fatal(err)
+			}
+			plan = append(plan, detail)
+		}
+		rows.Close()
+		if got := strings.Join(plan, "; "); !strings.Contains(got, "todos_importance") || strings.Contains(got, "TEMP B-TREE") {
+			t.Error("Importance order does not use its index", where, got)
+		}
+	}
+
+	// a status moving to another category moves its todos with it
+	status, err := testStore.InsertStatus(ctx, models.Status{Name: "Importance Parked", Category: models.CategoryOpen})
+	if err != nil {
+		t.Fatal("Error adding status", err)
+	}
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "importance parked"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	parked := models.ToDoStatus(status.ID)
+	if _, err := testStore.PatchTodo(ctx, todo.ID, TodoPatch{Status: &parked}); err != nil {
+		t.Fatal("Error moving todo", err)
+	}
+	category := func() string {
+		var category string
+		if err := testStore.db.QueryRowContext(ctx, "SELECT statusCategory FROM todos WHERE id=?", todo.ID).Scan(&category); err != nil {
+			t.Fatal(err)
+		}
+		return category
+	}
+	if got := category(); got != "open" {
+		t.Error("Unexpected status category", got)
+	}
+	status.Category = models.CategoryDone
+	if _, err := testStore.UpdateStatus(ctx, status.ID, status); err != nil {
+		t.Fatal("Error updating status", err)
+	}
+	if got := category(); got != "done" {
+		t.Error("Status category did not follow its status", got)
+	}
+}
+
+func TestDBPriorityOrder(t *testing.T) {
+	for _, todo := range []models.ToDo{
+		{Description: "low", Priority: models.PriorityLow},
+		{Description: "closed urgent", Priority: models.PriorityUrgent},
+		{Description: "urgent", Priority: models.PriorityUrgent},
+		{Description: "none"},
+		{Description: "high", Priority: models.PriorityHigh},
+	} {
+		todo.Title = "priority order"
+		inserted, err := testStore.InsertTodo(ctx, todo)
+		if err != nil {
+			t.Fatal("Error in adding new Todo", err)
+		}
+		if inserted.Priority != todo.Priority {
+			t.Error("Priority was not stored", inserted)
+		}
+		if todo.Description == "closed urgent" {
+			closed := models.Closed
+			if _, err := testStore.PatchTodo(ctx, inserted.ID, TodoPatch{Status: &closed}); err != nil {
+				t.Fatal("Error closing todo", err)
+			}
+		}
+	}
+
+	// walk the default order one todo per page to exercise the cursor
+	var order []string
+	page := Page{Limit: 1}
+	for {
+		todos, next, err := testStore.GetAllTodos(ctx, TodoFilter{Title: "priority order"}, page)
+		if err != nil {
+			t.Fatal("Error fetching todos", err)
+		}
+		for _, todo := range todos {
+			order = append(order, todo.Description)
+		}
+		if next == "" {
+			break
+		}
+		page.Cursor = next
+	}
+	if got := strings.Join(order, ","); got != "urgent,high,low,none,closed urgent" {
+		t.Error("Unexpected default order", got)
+	}
+
+	todos, _, err := testStore.GetAllTodos(ctx, TodoFilter{Title: "priority order", Priorities: []models.Priority{models.PriorityUrgent}, Sort: "id"}, Page{})
+	if err != nil {
+		t.Fatal("Error fetching todos", err)
+	}
+	if len(todos) != 2 || todos[0].Description != "closed urgent" {
+		t.Error("Unexpected todos filtered by priority", todos)
+	}
+}
+
+func TestDBSubtasks(t *testing.T) {
+	insert := func(title string, parent int64, autoClose bool) models.ToDo {
+		todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: title, ParentID: parent, AutoClose: autoClose})
+		if err != nil {
+			t.Fatal("Error in adding new Todo", err)
+		}
+		return todo
+	}
+	parent := insert("subtask parent", 0, true)
+	first := insert("subtask first", parent.ID, false)
+	second := insert("subtask second", parent.ID, false)
+	nested := insert("subtask nested", first.ID, false)
+
+	subtree, err := testStore.SubtreeTodos(ctx, parent.ID)
+	if err != nil {
+		t.Fatal("Error listing subtree", err)
+	}
+	var titles []string
+	for _, todo := range subtree {
+		titles = append(titles, todo.Title)
+	}
+	if got := strings.Join(titles, ","); got != "subtask parent,subtask first,subtask nested,subtask second" {
+		t.Error("Unexpected subtree order", got)
+	}
+	if p := subtree[0].Progress; p == nil || p.Subtasks != 3 || p.Closed != 0 {
+		t.Error("Unexpected progress", p)
+	}
+
+	children, _, err := testStore.GetAllTodos(ctx, TodoFilter{Parent: parent.ID, Sort: "id"}, Page{})
+	if err != nil {
+		t.Fatal("Error fetching children", err)
+	}
+	if len(children) != 2 || children[0].ID != first.ID || children[0].Progress == nil {
+		t.Error("Unexpected children", children)
+	}
+
+	// cycles are refused
+	for _, parentID := range []int64{parent.ID, nested.ID} {
+		if _, err := testStore.PatchTodo(ctx, parent.ID, TodoPatch{ParentID: &parentID}); !errors.Is(err, ErrConflict) {
+			t.Error("Expected ErrConflict for a cycle, got", parentID, err)
+		}
+	}
+	missing := int64(1 << 40)
+	if _, err := testStore.PatchTodo(ctx, first.ID, TodoPatch{ParentID: &missing}); !errors.Is(err, ErrInvalid) {
+		t.Error("Expected ErrInvalid for a missing parent, got", err)
+	}
+
+	// the parent closes with its last open child, the first child does not
+	// ask for autoClose and stays open after its own child closes
+	closed := models.Closed
+	for _, todo := range []models.ToDo{nested, second} {
+		if _, err := testStore.PatchTodo(ctx, todo.ID, TodoPatch{Status: &closed}); err != nil {
+			t.Fatal("Error closing todo", err)
+		}
+	}
+	if got, _ := testStore.GetTodo(ctx, first.ID); got.Status != models.Open {
+		t.Error("Child without autoClose was closed", got.Status)
+	}
+	if got, _ := testStore.GetTodo(ctx, parent.ID); got.Status != models.Open || got.Progress.Percent != 66 {
+		t.Error("Parent closed early or has the wrong progress", got.Status, got.Progress)
+	}
+	if _, err := testStore.UpdateTodo(ctx, first.ID, models.ToDo{Title: first.Title, Status: models.Closed, ParentID: parent.ID}, false); err != nil {
+		t.Fatal("Error closing todo", err)
+	}
+	if got, _ := testStore.GetTodo(ctx, parent.ID); got.Status != models.Closed || got.Progress.Percent != 100 {
+		t.Error("Parent was not closed with its last child", got.Status, got.Progress)
+	}
+
+	// deleting a parent promotes its children
+	if _, err := testStore.DeleteTodo(ctx, parent.ID); err != nil {
+		t.Fatal("Error deleting todo", err)
+	}
+	if got, _ := testStore.GetTodo(ctx, first.ID); got.ParentID != 0 {
+		t.Error("Child still points at its deleted parent", got.ParentID)
+	}
+}
+
+func TestDBAutoCloseOnDeleteAndMove(t *testing.T) {
+	insert := func(title string, parent int64, autoClose bool) models.ToDo {
+		todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: title, ParentID: parent, AutoClose: autoClose})
+		if err != nil {
+			t.Fatal("Error in adding new Todo", err)
+		}
+		return todo
+	}
+	closed := models.Closed
+
+	// deleting the last open subtask finishes the parent
+	parent := insert("left parent", 0, true)
+	done := insert("left done", parent.ID, false)
+	open := insert("left open", parent.ID, false)
+	if _, err := testStore.PatchTodo(ctx, done.ID, TodoPatch{Status: &closed}); err != nil {
+		t.Fatal("Error closing todo", err)
+	}
+	if _, err := testStore.DeleteTodo(ctx, open.ID); err != nil {
+		t.Fatal("Error deleting todo", err)
+	}
+	if got, _ := testStore.GetTodo(ctx, parent.ID); got.Status != models.Closed {
+		t.Error("Parent stayed open after its last open subtask was deleted", got.Status)
+	}
+
+	// so does moving it to another parent
+	parent = insert("moved parent", 0, true)
+	done = insert("moved done", parent.ID, false)
+	open = insert("moved open", parent.ID, false)
+	if _, err := testStore.PatchTodo(ctx, done.ID, TodoPatch{Status: &closed}); err != nil {
+		t.Fatal("Error closing todo", err)
+	}
+	other := insert("moved other parent", 0, false)
+	if _, err := testStore.PatchTodo(ctx, open.ID, TodoPatch{ParentID: &other.ID}); err != nil {
+		t.Fatal("Error moving todo", err)
+	}
+	if got, _ := testStore.GetTodo(ctx, parent.ID); got.Status != models.Closed {
+		t.Error("Parent stayed open after its last open subtask moved away", got.Status)
+	}
+	if got, _ := testStore.GetTodo(ctx, other.ID); got.Status != models.Open {
+		t.Error("Parent without autoClose was closed", got.Status)
+	}
+
+	// a parent left without subtasks stays as it is
+	parent = insert("emptied parent", 0, true)
+	open = insert("emptied open", parent.ID, false)
+	if _, err := testStore.DeleteTodo(ctx, open.ID); err != nil {
+		t.Fatal("Error deleting todo", err)
+	}
+	if got, _ := testStore.GetTodo(ctx, parent.ID); got.Status != models.Open {
+		t.Error("Parent without subtasks was closed", got.Status)
+	}
+}
+
+func TestDBBlockers(t *testing.T) {
+	insert := func(title string) models.ToDo {
+		todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: title})
+		if err != nil {
+			t.Fatal("Error in adding new Todo", err)
+		}
+		return todo
+	}
+	design := insert("blocker design")
+	build := insert("blocker build")
+	ship := insert("blocker ship")
+
+	for _, pair := range [][2]int64{{build.ID, design.ID}, {ship.ID, build.ID}} {
+		if err := testStore.AddBlocker(ctx, pair[0], pair[1]); err != nil {
+			t.Fatal("Error adding blocker", err)
+		}
+	}
+	if err := testStore.AddBlocker(ctx, build.ID, design.ID); !errors.Is(err, ErrConflict) {
+		t.Error("Expected ErrConflict for a duplicate blocker, got", err)
+	}
+	for _, pair := range [][2]int64{{design.ID, ship.ID}, {design.ID, design.ID}} {
+		if err := testStore.AddBlocker(ctx, pair[0], pair[1]); !errors.Is(err, ErrConflict) {
+			t.Error("Expected ErrConflict for a cycle, got", pair, err)
+		}
+	}
+	if err := testStore.AddBlocker(ctx, ship.ID, 1<<40); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound for a missing blocker, got", err)
+	}
+
+	blockers, err := testStore.GetBlockers(ctx, ship.ID)
+	if err != nil {
+		t.Fatal("Error listing blockers", err)
+	}
+	if len(blockers) != 1 || blockers[0].ID != build.ID {
+		t.Error("Unexpected blockers", blockers)
+	}
+
+	ready, _, err := testStore.GetAllTodos(ctx, TodoFilter{Ready: true, Title: "blocker ", Sort: "id"}, Page{})
+	if err != nil {
+		t.Fatal("Error listing ready todos", err)
+	}
+	if len(ready) != 1 || ready[0].ID != design.ID {
+		t.Error("Unexpected ready todos", ready)
+	}
+
+	// build waits on design, unless forced
+	started := models.InProgress
+	if _, err := testStore.PatchTodo(ctx, build.ID, TodoPatch{Status: &started}); !errors.Is(err, ErrConflict) {
+		t.Error("Expected ErrConflict starting a blocked todo, got", err)
+	}
+	if _, err := testStore.PatchTodo(ctx, ship.ID, TodoPatch{Status: &started, Force: true}); err != nil {
+		t.Error("Forced start failed", err)
+	}
+	closed := models.Closed
+	if _, err := testStore.PatchTodo(ctx, design.ID, TodoPatch{Status: &closed}); err != nil {
+		t.Fatal("Error closing todo", err)
+	}
+	if _, err := testStore.PatchTodo(ctx, build.ID, TodoPatch{Status: &started}); err != nil {
+		t.Error("Unblocked todo could not start", err)
+	}
+
+	if err := testStore.RemoveBlocker(ctx, ship.ID, build.ID); err != nil {
+		t.Error("Error removing blocker", err)
+	}
+	if err := testStore.RemoveBlocker(ctx, ship.ID, build.ID); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound removing a missing blocker, got", err)
+	}
+
+	// deleting a todo drops its dependencies
+	if _, err := testStore.DeleteTodo(ctx, design.ID); err != nil {
+		t.Fatal("Error deleting todo", err)
+	}
+	if blockers, _ := testStore.GetBlockers(ctx, build.ID); len(blockers) != 0 {
+		t.Error("Blocker survived its deletion", blockers)
+	}
+}
+
+func TestDBBlockedParentStaysOpen(t *testing.T) {
+	insert := func(todo models.ToDo) models.ToDo {
+		todo, err := testStore.InsertTodo(ctx, todo)
+		if err != nil {
+			t.Fatal("Error in adding new Todo", err)
+		}
+		return todo
+	}
+	parent := insert(models.ToDo{Title: "blocked parent", AutoClose: true})
+	child := insert(models.ToDo{Title: "blocked parent child", ParentID: parent.ID})
+	blocker := insert(models.ToDo{Title: "blocked parent blocker"})
+	if err := testStore.AddBlocker(ctx, parent.ID, blocker.ID); err != nil {
+		t.Fatal("Error adding blocker", err)
+	}
+
+	closed := models.Closed
+	if _, err := testStore.PatchTodo(ctx, child.ID, TodoPatch{Status: &closed}); err != nil {
+		t.Fatal("Error closing todo", err)
+	}
+	if got, _ := testStore.GetTodo(ctx, parent.ID); got.Status != models.Open {
+		t.Error("Blocked parent was auto-closed", got.Status)
+	}
+}
+
+func TestDBRecurringTodos(t *testing.T) {
+	if _, err := testStore.InsertTodo(ctx, models.ToDo{Title: "recurring without due", Recurrence: "FREQ=DAILY"}); !errors.Is(err, ErrInvalid) {
+		t.Error("Expected ErrInvalid for a recurring todo without dueAt, got", err)
+	}
+
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "recurring chore", DueAt: "2026-10-19", Recurrence: "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=2"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	tag, err := testStore.InsertTag(ctx, models.Tag{Name: "recurring chores"})
+	if err != nil {
+		t.Fatal("Error in adding new Tag", err)
+	}
+	if _, err := testStore.AssociateTag(ctx, tag.ID, todo.ID); err != nil {
+		t.Fatal("Error associating tag", err)
+	}
+
+	next := func() []models.ToDo {
+		todos, _, err := testStore.GetAllTodos(ctx, TodoFilter{Title: "recurring chore", Statuses: []models.ToDoStatus{models.Open}}, Page{})
+		if err != nil {
+			t.Fatal("Error listing todos", err)
+		}
+		return todos
+	}
+
+	todo.Status = models.Closed
+	if _, err := testStore.UpdateTodo(ctx, todo.ID, todo, false); err != nil {
+		t.Fatal("Error closing todo", err)
+	}
+	open := next()
+	if len(open) != 1 || open[0].DueAt != "2026-10-22" || open[0].Recurrence != "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=1" {
+		t.Fatal("Unexpected next occurrence", open)
+	}
+	if len(open[0].Tags) != 1 || open[0].Tags[0].ID != tag.ID {
+		t.Error("Next occurrence lost its tags", open[0].Tags)
+	}
+
+	// the rule moved to the next occurrence, so reopening and closing the
+	// todo does not fork the series
+	if got, _ := testStore.GetTodo(ctx, todo.ID); got.Recurrence != "" {
+		t.Error("Closed todo kept its rule", got.Recurrence)
+	}
+	reopened, closed := models.Open, models.Closed
+	for _, status := range []*models.ToDoStatus{&reopened, &closed} {
+		if _, err := testStore.PatchTodo(ctx, todo.ID, TodoPatch{Status: status}); err != nil {
+			t.Fatal("Error changing status", err)
+		}
+	}
+	if open := next(); len(open) != 1 {
+		t.Error("Reopening and closing forked the series", open)
+	}
+
+	// closing again does not repeat the occurrence, and the series ends
+	// with the last one
+	if _, err := testStore.UpdateTodo(ctx, todo.ID, todo, false); err != nil {
+		t.Fatal("Error updating todo", err)
+	}
+	last := open[0]
+	last.Status = models.Closed
+	if _, err := testStore.UpdateTodo(ctx, last.ID, last, false); err != nil {
+		t.Fatal("Error closing todo", err)
+	}
+	if open := next(); len(open) != 0 {
+		t.Error("Series went on past COUNT", open)
+	}
+}
+
+func TestDBRecurringAutoClose(t *testing.T) {
+	parent, err := testStore.InsertTodo(ctx, models.ToDo{Title: "recurring parent", AutoClose: true, DueAt: "2026-10-19", Recurrence: "FREQ=DAILY"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	child, err := testStore.InsertTodo(ctx, models.ToDo{Title: "recurring parent child", ParentID: parent.ID})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+
+	closed := models.Closed
+	if _, err := testStore.PatchTodo(ctx, child.ID, TodoPatch{Status: &closed}); err != nil {
+		t.Fatal("Error closing todo", err)
+	}
+	if got, _ := testStore.GetTodo(ctx, parent.ID); got.Status != models.Closed {
+		t.Fatal("Parent was not auto-closed", got.Status)
+	}
+	open, _, err := testStore.GetAllTodos(ctx, TodoFilter{Title: "recurring parent", Statuses: []models.ToDoStatus{models.Open}}, Page{})
+	if err != nil {
+		t.Fatal("Error listing todos", err)
+	}
+	if len(open) != 1 || open[0].DueAt != "2026-10-20" || open[0].Recurrence != "FREQ=DAILY" {
+		t.Error("Auto-closed parent did not schedule its next occurrence", open)
+	}
+}
+
+func TestDBStatusWorkflow(t *testing.T) {
+	for _, transitions := range [][]string{{"open closed"}, {"open -> "}} {
+		if _, err := ParseWorkflow(transitions); err == nil {
+			t.Error("Expected an error for a malformed transition", transitions)
+		}
+	}
+	nowhere, err := ParseWorkflow([]string{"open -> nowhere"})
+	if err != nil {
+		t.Fatal("Error parsing workflow", err)
+	}
+	if err := testStore.SetWorkflow(ctx, nowhere); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound for a transition to an unknown status, got", err)
+	}
+
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "workflow"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+
+	for _, status := range []models.ToDoStatus{models.InProgress, models.InProgress, models.Closed} {
+		if _, err := testStore.PatchTodo(ctx, todo.ID, TodoPatch{Status: &status}); err != nil {
+			t.Fatal("Error changing status", status, err)
+		}
+	}
+	started, unknown := models.InProgress, models.ToDoStatus(1<<30)
+	if _, err := testStore.PatchTodo(ctx, todo.ID, TodoPatch{Status: &started}); !errors.Is(err, ErrConflict) {
+		t.Error("Expected ErrConflict for Closed to In Progress, got", err)
+	}
+	if _, err := testStore.PatchTodo(ctx, todo.ID, TodoPatch{Status: &unknown}); !errors.Is(err, ErrInvalid) {
+		t.Error("Expected ErrInvalid for an unknown status, got", err)
+	}
+
+	history, err := testStore.StatusHistory(ctx, todo.ID)
+	if err != nil {
+		t.Fatal("Error fetching history", err)
+	}
+	if len(history) != 2 || history[0].From != models.Open || history[0].To != models.InProgress || history[1].To != models.Closed || history[1].ChangedAt == "" {
+		t.Error("Unexpected history", history)
+	}
+	if _, err := testStore.StatusHistory(ctx, 1<<40); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound for a missing todo, got", err)
+	}
+
+	// a custom workflow that only allows closing
+	workflow, err := ParseWorkflow([]string{"open -> closed", "1 -> 2"})
+	if err != nil {
+		t.Fatal("Error parsing workflow", err)
+	}
+	if err := testStore.SetWorkflow(ctx, workflow); err != nil {
+		t.Fatal("Error setting workflow", err)
+	}
+	defer testStore.SetWorkflow(ctx, Workflow{})
+	reopened := models.Open
+	if _, err := testStore.PatchTodo(ctx, todo.ID, TodoPatch{Status: &reopened}); !errors.Is(err, ErrConflict) {
+		t.Error("Expected ErrConflict reopening under the custom workflow, got", err)
+	}
+}
+
+func TestDBCustomStatuses(t *testing.T) {
+	statuses, err := testStore.GetAllStatuses(ctx)
+	if err != nil {
+		t.Fatal("Error listing statuses", err)
+	}
+	if len(statuses) < 3 || statuses[0].Name != "Open" || statuses[1].Category != models.CategoryActive || statuses[2].Category != models.CategoryDone {
+		t.Fatal("Unexpected seeded statuses", statuses)
+	}
+
+	review, err := testStore.InsertStatus(ctx, models.Status{Name: "Custom Review", Category: models.CategoryActive})
+	if err != nil {
+		t.Fatal("Error adding status", err)
+	}
+	wontDo, err := testStore.InsertStatus(ctx, models.Status{Name: "Custom Won't Do", Category: models.CategoryDone})
+	if err != nil {
+		t.Fatal("Error adding status", err)
+	}
+	if wontDo.Order <= review.Order || review.Order <= statuses[2].Order {
+		t.Error("New statuses were not placed last", review.Order, wontDo.Order)
+	}
+	for _, name := range []string{"custom review", "CustomReview", "custom-review"} {
+		if _, err := testStore.InsertStatus(ctx, models.Status{Name: name, Category: models.CategoryOpen}); !errors.Is(err, ErrConflict) {
+			t.Error("Expected ErrConflict for a duplicate name, got", name, err)
+		}
+	}
+	review.Name = "in_progress"
+	if _, err := testStore.UpdateStatus(ctx, review.ID, review); !errors.Is(err, ErrConflict) {
+		t.Error("Expected ErrConflict renaming to a duplicate name, got", err)
+	}
+	review.Name = "Custom Review"
+
+	parent, err := testStore.InsertTodo(ctx, models.ToDo{Title: "custom status parent", AutoClose: true})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "custom status", ParentID: parent.ID})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+
+	for _, id := range []int64{review.ID, wontDo.ID} {
+		status := models.ToDoStatus(id)
+		if _, err := testStore.PatchTodo(ctx, todo.ID, TodoPatch{Status: &status}); err != nil {
+			t.Fatal("Error moving todo", id, err)
+		}
+	}
+
+	// Won't Do finishes the todo like Closed does
+	if got, _ := testStore.GetTodo(ctx, parent.ID); got.Status != models.Closed || got.Progress == nil || got.Progress.Closed != 1 {
+		t.Error("Parent was not auto-closed", got.Status, got.Progress)
+	}
+	open, _, err := testStore.GetAllTodos(ctx, TodoFilter{Title: "custom status", ExcludeClosed: true}, Page{})
+	if err != nil {
+		t.Fatal("Error listing todos", err)
+	}
+	if len(open) != 0 {
+		t.Error("Done todos were not excluded", open)
+	}
+	byName, _, err := testStore.GetAllTodos(ctx, TodoFilter{StatusNames: []string{"custom_won't_do"}, Categories: []models.StatusCategory{models.CategoryDone}}, Page{})
+	if err != nil {
+		t.Fatal("Error listing todos", err)
+	}
+	if len(byName) != 1 || byName[0].ID != todo.ID {
+		t.Error("Unexpected todos by status name", byName)
+	}
+
+	// the seeded statuses can be renamed but keep their category
+	closed := statuses[2]
+	closed.Name = "Finished"
+	if renamed, err := testStore.UpdateStatus(ctx, closed.ID, closed); err != nil || renamed.Name != "Finished" {
+		t.Error("Error renaming a seeded status", renamed, err)
+	}
+	unordered := closed
+	unordered.Order = 0
+	if renamed, err := testStore.UpdateStatus(ctx, closed.ID, unordered); err != nil || renamed.Order != closed.Order {
+		t.Error("An order of 0 moved the status", renamed, err)
+	}
+	closed.Name, closed.Category = "Closed", models.CategoryActive
+	if _, err := testStore.UpdateStatus(ctx, closed.ID, closed); !errors.Is(err, ErrConflict) {
+		t.Error("Expected ErrConflict moving a seeded status to another category, got", err)
+	}
+	closed.Category = models.CategoryDone
+	if _, err := testStore.UpdateStatus(ctx, closed.ID, closed); err != nil {
+		t.Error("Error renaming a seeded status back", err)
+	}
+
+	if _, err := testStore.DeleteStatus(ctx, closed.ID); !errors.Is(err, ErrConflict) {
+		t.Error("Expected ErrConflict deleting a seeded status, got", err)
+	}
+	if _, err := testStore.DeleteStatus(ctx, wontDo.ID); !errors.Is(err, ErrConflict) {
+		t.Error("Expected ErrConflict deleting a status in use, got", err)
+	}
+	if _, err := testStore.DeleteStatus(ctx, review.ID); !errors.Is(err, ErrConflict) {
+		t.Error("Expected ErrConflict deleting a status in the history, got", err)
+	}
+
+	// an unused status goes, and its id is not handed out again
+	unused, err := testStore.InsertStatus(ctx, models.Status{Name: "Custom Unused", Category: models.CategoryOpen})
+	if err != nil {
+		t.Fatal("Error adding status", err)
+	}
+	if _, err := testStore.DeleteStatus(ctx, unused.ID); err != nil {
+		t.Error("Error deleting an unused status", err)
+	}
+	if _, err := testStore.GetStatus(ctx, unused.ID); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound for a deleted status, got", err)
+	}
+	next, err := testStore.InsertStatus(ctx, models.Status{Name: "Custom Next", Category: models.CategoryOpen})
+	if err != nil {
+		t.Fatal("Error adding status", err)
+	}
+	if next.ID == unused.ID {
+		t.Error("The id of a deleted status was reused", next.ID)
+	}
+	if _, err := testStore.DeleteStatus(ctx, next.ID); err != nil {
+		t.Error("Error deleting an unused status", err)
+	}
+}
+
+func TestDBDeleteStatusInWorkflow(t *testing.T) {
+	store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "workflow.db"))
+	if err != nil {
+		t.Fatal("Cannot open store", err)
+	}
+	defer store.Close()
+
+	review, err := store.InsertStatus(ctx, models.Status{Name: "Review", Category: models.CategoryActive})
+	if err != nil {
+		t.Fatal("Error adding status", err)
+	}
+	workflow, err := ParseWorkflow([]string{"open -> review", "review -> closed"})
+	if err != nil {
+		t.Fatal("Error parsing workflow", err)
+	}
+	if err := store.SetWorkflow(ctx, workflow); err != nil {
+		t.Fatal("Error setting workflow", err)
+	}
+	if _, err := store.DeleteStatus(ctx, review.ID); !errors.Is(err, ErrConflict) {
+		t.Error("Expected ErrConflict deleting a status of the workflow, got", err)
+	}
+}
+
+func TestDBNewTodosStartInFirstOpenStatus(t *testing.T) {
+	store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "statuses.db"))
+	if err != nil {
+		t.Fatal("Cannot open store", err)
+	}
+	defer store.Close()
+
+	backlog, err := store.InsertStatus(ctx, models.Status{Name: "Backlog", Category: models.CategoryOpen})
+	if err != nil {
+		t.Fatal("Error adding status", err)
+	}
+	open, err := store.GetStatus(ctx, int64(models.Open))
+	if err != nil {
+		t.Fatal("Error fetching status", err)
+	}
+	open.Order = backlog.Order + 1
+	if _, err := store.UpdateStatus(ctx, open.ID, open); err != nil {
+		t.Fatal("Error moving status", err)
+	}
+
+	todo, err := store.InsertTodo(ctx, models.ToDo{Title: "first open", DueAt: "2026-10-19", Recurrence: "FREQ=DAILY"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	if todo.Status != models.ToDoStatus(backlog.ID) {
+		t.Error("New todo did not start in the first open status", todo.Status)
+	}
+
+	closed := models.Closed
+	if _, err := store.PatchTodo(ctx, todo.ID, TodoPatch{Status: &closed}); err != nil {
+		t.Fatal("Error closing todo", err)
+	}
+	next, _, err := store.GetAllTodos(ctx, TodoFilter{Statuses: []models.ToDoStatus{models.ToDoStatus(backlog.ID)}}, Page{})
+	if err != nil {
+		t.Fatal("Error listing todos", err)
+	}
+	if len(next) != 1 || next[0].DueAt != "2026-10-20" {
+		t.Error("Next occurrence did not start in the first open status", next)
+	}
+}
+
+func TestDBConcurrentWrites(t *testing.T) {
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "concurrent"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+
+	const writers = 100
+	errs := make(chan error, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			title := fmt.Sprintf("concurrent %d", i)
+			status := models.ToDoStatus(i % 2)
+			_, err := testStore.PatchTodo(ctx, todo.ID, TodoPatch{Title: &title, Status: &status})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error("Concurrent write failed", err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"go-todo/models"
+)
+
+// openBlockers selects the ids of the todos blocked by a todo whose status
+// is not of the done category
+const openBlockers = "SELECT b.todo_id FROM todos_blockers b JOIN todos bt ON bt.id = b.blocker_id WHERE bt.status NOT IN " + doneStatuses
+
+// AddBlocker records that todo todoID is blocked by todo blockerID. A
+// dependency that would make a todo wait on itself is a conflict.
+func (s *SQLiteStore) AddBlocker(ctx context.Context, todoID, blockerID int64) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range []int64{todoID, blockerID} {
+			var exists bool
+			if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM todos WHERE id=?)", id).Scan(&exists); err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("todo %v: %w", id, ErrNotFound)
+			}
+		}
+		if todoID == blockerID {
+			return conflictf("todo %v cannot block itself", todoID)
+		}
+
+		// follow what the blocker waits on; reaching the todo closes a cycle
+		var cycle bool
+		err := tx.QueryRowContext(ctx, `WITH RECURSIVE waits(id) AS (
+				SELECT ?
+				UNION SELECT b.blocker_id FROM todos_blockers b JOIN waits w ON b.todo_id = w.id
+			)
+			SELECT EXISTS (SELECT 1 FROM waits WHERE id=?)`, blockerID, todoID).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return conflictf("todo %v already waits on todo %v, blocking it would make a cycle", blockerID, todoID)
+		}
+
+		if _, err := tx.ExecContext(ctx, "INSERT INTO todos_blockers (todo_id, blocker_id) VALUES (?, ?)", todoID, blockerID); err != nil {
+			return storeError(err)
+		}
+		return nil
+	})
+}
+
+// RemoveBlocker deletes the dependency of todo todoID on todo blockerID
+func (s *SQLiteStore) RemoveBlocker(ctx context.Context, todoID, blockerID int64) error {
+	response, err := s.db.ExecContext(ctx, "DELETE FROM todos_blockers WHERE todo_id=? AND blocker_id=?", todoID, blockerID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := response.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("todo %v is not blocked by todo %v: %w", todoID, blockerID, ErrNotFound)
+	}
+	return nil
+}
+
+// GetBlockers returns the todos that todo todoID waits on, open or not
+func (s *SQLiteStore) GetBlockers(ctx context.Context, todoID int64) ([]models.ToDo, error) {
+	if _, err := s.GetTodo(ctx, todoID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+todoColumns+" FROM todos_blockers b JOIN todos t ON t.id = b.blocker_id WHERE b.todo_id=? ORDER BY t.id", todoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	todos := []models.ToDo{}
+	for rows.Next() {
+		var todo models.ToDo
+		if err := rows.Scan(todoFields(&todo)...); err != nil {
+			return nil, fmt.Errorf("getBlockers: unable to scan the row: %w", err)
+		}
+		todos = append(todos, todo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.attachTags(ctx, todos); err != nil {
+		return nil, err
+	}
+	return todos, attachProgress(ctx, s.db, todos)
+}
+
+// checkUnblocked refuses to move todo id to an active or done status while
+// it waits on an open blocker
+func checkUnblocked(ctx context.Context, q querier, id int64, from, to models.Status) error {
+	if from.ID == to.ID || to.Category == models.CategoryOpen {
+		return nil
+	}
+
+	var blockers int
+	err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM todos_blockers b JOIN todos bt ON bt.id = b.blocker_id WHERE b.todo_id=? AND bt.status NOT IN "+doneStatuses, id).Scan(&blockers)
+	if err != nil {
+		return err
+	}
+	if blockers > 0 {
+		return conflictf("todo %v waits on %d open blockers, close them first or force the change", id, blockers)
+	}
+	return nil
+}
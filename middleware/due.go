@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Due dates are stored twice: dueAt keeps the value the client sent, either
+// a date such as 2026-10-20 or an RFC 3339 time with its offset, and dueTime
+// holds the instant of a time in unix seconds, NULL for dates. A date has no
+// instant of its own, so it is compared as a calendar day in the time zone
+// of the query.
+
+const dateLayout = "2006-01-02"
+
+// DefaultUpcomingDays is the window of the upcoming view when days is not given
+const DefaultUpcomingDays = 7
+
+// parseDue validates a due date and returns its stored form: the canonical
+// text and, for times, the instant
+func parseDue(v string) (string, sql.NullInt64, error) {
+	if d, err := time.Parse(dateLayout, v); err == nil {
+		return d.Format(dateLayout), sql.NullInt64{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.Format(time.RFC3339), sql.NullInt64{Int64: t.Unix(), Valid: true}, nil
+	}
+	return "", sql.NullInt64{}, invalidf("dueAt must be a YYYY-MM-DD date or an RFC 3339 time with a time zone, got %q", v)
+}
+
+// dueColumns returns the dueAt and dueTime values to store for dueAt, NULLs
+// when the todo is not due
+func dueColumns(dueAt string) (sql.NullString, sql.NullInt64, error) {
+	if dueAt == "" {
+		return sql.NullString{}, sql.NullInt64{}, nil
+	}
+	text, instant, err := parseDue(dueAt)
+	return sql.NullString{String: text, Valid: true}, instant, err
+}
+
+// dueSortKey orders todos by due instant, dates at midnight in loc, with
+// the todos that are not due last. The zone name is written into the query
+// as a literal so the key can sit in ORDER BY and the cursor condition
+// alike; it comes from time.LoadLocation, and is quoted all the same.
+func dueSortKey(loc *time.Location) string {
+	zone := "'" + strings.ReplaceAll(loc.String(), "'", "''") + "'"
+	return "COALESCE(dueTime, due_instant(dueAt, " + zone + "), 9223372036854775807)"
+}
+
+// dueZones caches the zones loaded by dueInstant, which runs for every row
+var dueZones sync.Map
+
+// dueInstant is the SQL function due_instant: the unix time of a stored
+// dueAt, a date counting from its midnight in the named zone
+func dueInstant(dueAt, zone string) (int64, error) {
+	loc, ok := dueZones.Load(zone)
+	if !ok {
+		l, err := time.LoadLocation(zone)
+		if err != nil {
+			return 0, err
+		}
+		loc, _ = dueZones.LoadOrStore(zone, l)
+	}
+	if d, err := time.ParseInLocation(dateLayout, dueAt, loc.(*time.Location)); err == nil {
+		return d.Unix(), nil
+	}
+	t, err := time.Parse(time.RFC3339, dueAt)
+	return t.Unix(), err
+}
+
+// dueAfter returns the condition keeping todos due at or after t. Dates
+// match from the day of t in loc.
+func dueAfter(t time.Time, loc *time.Location) (string, []interface{}) {
+	return "(dueTime >= ? OR (dueTime IS NULL AND dueAt >= ?))", []interface{}{t.Unix(), t.In(loc).Format(dateLayout)}
+}
+
+// dueBefore returns the condition keeping todos due before t. Dates match
+// up to, but excluding, the day of t in loc.
+func dueBefore(t time.Time, loc *time.Location) (string, []interface{}) {
+	return "(dueTime < ? OR (dueTime IS NULL AND dueAt < ?))", []interface{}{t.Unix(), t.In(loc).Format(dateLayout)}
+}
+
+// startOfDay returns midnight of the day of t in loc
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// dueView narrows f to one of the due date views, relative to now:
+//
+//	overdue   due before now
+//	today     due today
+//	upcoming  due today or in the following days, DefaultUpcomingDays unless
+//	          given by the days parameter
+//
+// Every view leaves out todos in a done status and sorts by due date unless another
+// sort is asked for.
+func dueView(f *TodoFilter, view string, r *http.Request, now time.Time) error {
+	loc := f.location()
+	today := startOfDay(now, loc)
+
+	switch view {
+	case "overdue":
+		f.DueTo = now
+	case "today":
+		f.DueFrom, f.DueTo = today, today.AddDate(0, 0, 1)
+	case "upcoming":
+		days := DefaultUpcomingDays
+		if v := r.URL.Query().Get("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return errBadRequest{fmt.Errorf("days must be a non-negative integer, got %q", v)}
+			}
+			days = n
+		}
+		f.DueFrom, f.DueTo = today, today.AddDate(0, 0, days+1)
+	default:
+		return fmt.Errorf("unknown due view %q", view)
+	}
+
+	f.ExcludeClosed = true
+	if f.Sort == "" {
+		f.Sort = "dueAt"
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Errors returned by a TodoStore. Handlers map them onto HTTP status codes.
+var (
+	// ErrNotFound is returned when the requested todo or tag does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a write clashes with existing data
+	ErrConflict = errors.New("conflict")
+	// ErrInvalid is returned when a well-formed request carries unacceptable values
+	ErrInvalid = errors.New("invalid")
+	// ErrUnavailable is returned when the store lacks an optional feature
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// invalidf returns an error wrapping ErrInvalid with a client facing message
+func invalidf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
+
+// conflictf returns an error wrapping ErrConflict with a client facing message
+func conflictf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrConflict, fmt.Sprintf(format, args...))
+}
+
+// errBadRequest marks errors caused by a malformed request, such as an
+// unparsable body or path parameter
+type errBadRequest struct {
+	err error
+}
+
+func (e errBadRequest) Error() string { return e.err.Error() }
+func (e errBadRequest) Unwrap() error { return e.err }
+
+// problem is an RFC 7807 problem details body
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// writeProblem sends an application/problem+json response
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	}
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		slog.Error("unable to write the error response", "err", err)
+	}
+}
+
+// writeError maps err onto a status code and sends it as a problem response.
+// Unexpected errors are logged and reported without their details.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var badRequest errBadRequest
+	switch {
+	case errors.As(err, &badRequest):
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrNotFound):
+		writeProblem(w, r, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrConflict):
+		writeProblem(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrInvalid):
+		writeProblem(w, r, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, ErrUnavailable):
+		writeProblem(w, r, http.StatusNotImplemented, err.Error())
+	default:
+		slog.Error("request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+	}
+}
+
+// writeJSON encodes v as the response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("unable to write the response", "err", err)
+	}
+}
+
+// decodeBody decodes the JSON request body into v
+func decodeBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errBadRequest{fmt.Errorf("unable to decode the request body: %w", err)}
+	}
+	return nil
+}
+
+// pathID parses the numeric route parameter key
+func pathID(r *http.Request, key string) (int64, error) {
+	value := mux.Vars(r)[key]
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errBadRequest{fmt.Errorf("%s must be an integer, got %q", key, value)}
+	}
+	return id, nil
+}
+
+// isForeignKeyError reports whether err is a foreign key constraint failure
+func isForeignKeyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+}
+
+// storeError translates constraint failures into the store's sentinel
+// errors: a duplicate is a conflict, any other constraint an invalid value.
+// The driver message names tables and columns, so it is logged rather than
+// handed to clients.
+func storeError(err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrConstraint {
+		return err
+	}
+
+	slog.Debug("constraint failed", "err", err)
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return conflictf("an entry with the same values already exists")
+	default:
+		return invalidf("the values do not satisfy the constraints of the store")
+	}
+}
+
+// NotFound answers requests for unknown routes
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusNotFound, "no such endpoint")
+}
+
+// MethodNotAllowed answers requests using a method a route does not support
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusMethodNotAllowed, fmt.Sprintf("%s is not supported on this endpoint", r.Method))
+}
+
+// Recover turns a panic in a handler into a 500 problem response
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				writeError(w, r, fmt.Errorf("panic: %v", v))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"go-todo/models"
+)
+
+func serve(handler http.HandlerFunc, method, pattern, target, body string) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	router.HandleFunc(pattern, handler).Methods(method)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+func decodeProblem(t *testing.T, rec *httptest.ResponseRecorder) problem {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Error("Unexpected content type", ct)
+	}
+	var p problem
+	if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+		t.Fatal("Error decoding problem body", err)
+	}
+	if p.Status != rec.Code {
+		t.Error("Problem status does not match response code", p.Status, rec.Code)
+	}
+	return p
+}
+
+func TestProblemMalformedBody(t *testing.T) {
+	h := NewHandler(testStore)
+	rec := serve(h.CreateTodo, "POST", "/api/todo", "/api/todo", "{not json")
+
+	if rec.Code != http.StatusBadRequest {
+		t.Error("Expected 400 for a malformed body", rec.Code)
+	}
+	decodeProblem(t, rec)
+}
+
+func TestProblemNonNumericID(t *testing.T) {
+	h := NewHandler(testStore)
+	rec := serve(h.GetTodo, "GET", "/api/todo/{id}", "/api/todo/abc", "")
+
+	if rec.Code != http.StatusBadRequest {
+		t.Error("Expected 400 for a non-numeric id", rec.Code)
+	}
+	if p := decodeProblem(t, rec); p.Instance != "/api/todo/abc" {
+		t.Error("Problem instance does not match the request path", p.Instance)
+	}
+}
+
+func TestProblemValidation(t *testing.T) {
+	h := NewHandler(testStore)
+	rec := serve(h.CreateTodo, "POST", "/api/todo", "/api/todo", `{"title": "  "}`)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 for an empty title", rec.Code)
+	}
+	decodeProblem(t, rec)
+}
+
+func TestProblemNotFound(t *testing.T) {
+	h := NewHandler(testStore)
+	rec := serve(h.GetTag, "GET", "/api/tag/{id}", "/api/tag/999999", "")
+
+	if rec.Code != http.StatusNotFound {
+		t.Error("Expected 404 for an unknown tag", rec.Code)
+	}
+	decodeProblem(t, rec)
+}
+
+func TestProblemUnknownTodo(t *testing.T) {
+	h := NewHandler(testStore)
+
+	rec := serve(h.GetTodo, "GET", "/api/todo/{id}", "/api/todo/999999", "")
+	if rec.Code != http.StatusNotFound {
+		t.Error("Expected 404 fetching an unknown todo", rec.Code)
+	}
+
+	rec = serve(h.UpdateTodo, "PUT", "/api/todo/{id}", "/api/todo/999999", `{"title": "ghost"}`)
+	if rec.Code != http.StatusNotFound {
+		t.Error("Expected 404 updating an unknown todo", rec.Code)
+	}
+
+	rec = serve(h.DeleteTodo, "DELETE", "/api/todo/{id}", "/api/todo/999999", "")
+	if rec.Code != http.StatusNotFound {
+		t.Error("Expected 404 deleting an unknown todo", rec.Code)
+	}
+	decodeProblem(t, rec)
+}
+
+func TestProblemConstraint(t *testing.T) {
+	h := NewHandler(testStore)
+
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "constraint"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	tag, err := testStore.InsertTag(ctx, models.Tag{Name: "constraint"})
+	if err != nil {
+		t.Fatal("Error in adding new Tag", err)
+	}
+
+	target := fmt.Sprintf("/api/tag/todo/%d/%d", tag.ID, todo.ID)
+	serve(h.AssociateTag, "POST", "/api/tag/todo/{tagID}/{todoID}", target, "")
+	rec := serve(h.AssociateTag, "POST", "/api/tag/todo/{tagID}/{todoID}", target, "")
+	if rec.Code != http.StatusConflict {
+		t.Error("Expected 409 for a duplicate association", rec.Code)
+	}
+	if p := decodeProblem(t, rec); strings.Contains(p.Detail, "UNIQUE") || strings.Contains(p.Detail, "todos_tags") {
+		t.Error("Problem detail exposes the database", p.Detail)
+	}
+
+	// a CHECK failure is an invalid value, not a conflict
+	if _, err := testStore.InsertStatus(ctx, models.Status{Name: "constraint", Category: "stuck"}); !errors.Is(err, ErrInvalid) {
+		t.Error("Expected ErrInvalid for a failed CHECK, got", err)
+	}
+}
@@ -0,0 +1,389 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-todo/models"
+)
+
+// Tag matching modes for TodoFilter.TagMode
+const (
+	TagsAny = "any"
+	TagsAll = "all"
+)
+
+// todoImportance ranks todos by how urgently they need work: unfinished
+// todos by descending priority, then the ones in a done status. Lower ranks
+// come first. It is the expression of the todos_importance index, which
+// orders by the statusCategory copy rather than a lookup of the status.
+const todoImportance = "(CASE WHEN statusCategory = 'done' THEN 5 ELSE 0 END + 4 - priority)"
+
+// statusOrder places todos in the board order of their status
+const statusOrder = "(SELECT s.position FROM statuses s WHERE s.id = status)"
+
+// DefaultTodoSort orders todos when no sort is asked for
+const DefaultTodoSort = "importance"
+
+// todoSorts maps the sort names accepted by the API onto the column used in
+// ORDER BY and the expression selected as the cursor key
+var todoSorts = map[string]struct{ column, key string }{
+	"id":        {"id", "id"},
+	"createdAt": {"createdAt", "CAST(createdAt AS INTEGER)"},
+	"updatedAt": {"updatedAt", "CAST(updatedAt AS INTEGER)"},
+	"status":    {statusOrder, statusOrder},
+	"title":     {"title", "title"},
+	// the key of dueAt depends on the time zone, see dueSortKey
+	"dueAt":    {},
+	"priority": {"priority", "priority"},
+	// importance is ascending when the most important todos come first
+	"importance": {todoImportance, todoImportance},
+}
+
+// TodoFilter narrows and orders the todo list and chooses what each item
+// carries. The zero value lists every todo, with its tags, most important
+// open work first.
+type TodoFilter struct {
+	// Statuses and StatusNames keep todos in any of the given statuses, by
+	// id or by name
+	Statuses    []models.ToDoStatus
+	StatusNames []string
+	// Categories keeps todos whose status is of any of the given categories
+	Categories []models.StatusCategory
+	// Priorities keeps todos of any of the given priorities
+	Priorities []models.Priority
+	// Parent keeps the direct subtasks of the todo with this id
+	Parent int64
+	// Tags keeps todos carrying the named tags or tags below them, ignoring
+	// case, see TagMode
+	Tags []string
+	// TagMode is TagsAny (the default) or TagsAll
+	TagMode string
+	// CreatedFrom and CreatedTo bound createdAt, inclusive and exclusive
+	CreatedFrom, CreatedTo time.Time
+	// UpdatedFrom and UpdatedTo bound updatedAt, inclusive and exclusive
+	UpdatedFrom, UpdatedTo time.Time
+	// Title keeps todos whose title contains the text, ignoring case
+	Title string
+	// DueFrom and DueTo bound the due date, inclusive and exclusive. Todos
+	// due on a date rather than a time match by day in Location.
+	DueFrom, DueTo time.Time
+	// Location is the time zone of due dates, UTC when nil
+	Location *time.Location
+	// ExcludeClosed leaves out todos in a status of the done category
+	ExcludeClosed bool
+	// Ready keeps todos that wait on no open blocker
+	Ready bool
+
+	// Sort is one of importance (the default), id, createdAt, updatedAt,
+	// status, title, dueAt or priority
+	Sort string
+	// Desc reverses the sort order
+	Desc bool
+
+	// WithoutTags skips loading the tags of each todo
+	WithoutTags bool
+}
+
+// sortName returns the effective sort key, including its direction and,
+// for dueAt, the time zone that places dates
+func (f TodoFilter) sortName() string {
+	name := f.Sort
+	if name == "" {
+		name = DefaultTodoSort
+	}
+	if name == "dueAt" {
+		name += "@" + f.location().String()
+	}
+	if f.Desc {
+		return "-" + name
+	}
+	return name
+}
+
+// location returns the time zone of due dates
+func (f TodoFilter) location() *time.Location {
+	if f.Location == nil {
+		return time.UTC
+	}
+	return f.Location
+}
+
+// where builds the SQL conditions of the filter, joined with AND. The
+// conditions refer to the todos table unqualified.
+func (f TodoFilter) where() ([]string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	if len(f.Statuses) > 0 || len(f.StatusNames) > 0 {
+		var matches []string
+		if len(f.Statuses) > 0 {
+			matches = append(matches, "status IN ("+placeholders(len(f.Statuses))+")")
+			for _, s := range f.Statuses {
+				args = append(args, s)
+			}
+		}
+		if len(f.StatusNames) > 0 {
+			matches = append(matches, "status IN (SELECT id FROM statuses WHERE "+statusNameKey+" IN ("+placeholders(len(f.StatusNames))+"))")
+			for _, name := range f.StatusNames {
+				args = append(args, statusKey(name))
+			}
+		}
+		conds = append(conds, "("+strings.Join(matches, " OR ")+")")
+	}
+
+	if len(f.Categories) > 0 {
+		conds = append(conds, "status IN (SELECT id FROM statuses WHERE category IN ("+placeholders(len(f.Categories))+"))")
+		for _, c := range f.Categories {
+			args = append(args, c)
+		}
+	}
+
+	if f.Parent != 0 {
+		conds = append(conds, "parentId = ?")
+		args = append(args, f.Parent)
+	}
+
+	if len(f.Priorities) > 0 {
+		conds = append(conds, "priority IN ("+placeholders(len(f.Priorities))+")")
+		for _, p := range f.Priorities {
+			args = append(args, p)
+		}
+	}
+
+	// a tag matches todos carrying it or any tag below it
+	const tagged = "id IN (SELECT jt.todo_id FROM todos_tags jt JOIN tags t ON t.id = jt.tag_id WHERE "
+	if f.TagMode == TagsAll {
+		for _, tag := range f.Tags {
+			cond, tagArgs := tagSubtree("t.nameKey", tagKey(tag))
+			conds = append(conds, tagged+cond+")")
+			args = append(args, tagArgs...)
+		}
+	} else if len(f.Tags) > 0 {
+		var matches []string
+		for _, tag := range f.Tags {
+			cond, tagArgs := tagSubtree("t.nameKey", tagKey(tag))
+			matches = append(matches, cond)
+			args = append(args, tagArgs...)
+		}
+		conds = append(conds, tagged+strings.Join(matches, " OR ")+")")
+	}
+
+	for _, r := range []struct {
+		column string
+		op     string
+		value  time.Time
+	}{
+		{"createdAt", ">=", f.CreatedFrom},
+		{"createdAt", "<", f.CreatedTo},
+		{"updatedAt", ">=", f.UpdatedFrom},
+		{"updatedAt", "<", f.UpdatedTo},
+	} {
+		if !r.value.IsZero() {
+			conds = append(conds, r.column+" "+r.op+" ?")
+			args = append(args, r.value.Unix())
+		}
+	}
+
+	if f.Title != "" {
+		conds = append(conds, `title LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(f.Title)+"%")
+	}
+
+	if !f.DueFrom.IsZero() {
+		cond, dueArgs := dueAfter(f.DueFrom, f.location())
+		conds = append(conds, cond)
+		args = append(args, dueArgs...)
+	}
+	if !f.DueTo.IsZero() {
+		cond, dueArgs := dueBefore(f.DueTo, f.location())
+		conds = append(conds, cond)
+		args = append(args, dueArgs...)
+	}
+
+	if f.ExcludeClosed {
+		conds = append(conds, "status NOT IN "+doneStatuses)
+	}
+	if f.Ready {
+		conds = append(conds, "id NOT IN ("+openBlockers+")")
+	}
+
+	return conds, args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// placeholders returns n comma separated SQL parameters
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// todoFilterFromRequest reads the list filters from the query string:
+//
+//	status=open,1         statuses by name or id, repeatable
+//	category=open,active  status categories, repeatable
+//	priority=high,4       priorities by name or number, repeatable
+//	parentId=12           direct subtasks of a todo
+//	tag=home,urgent       tag names, repeatable
+//	tagMode=any|all       whether a todo needs any or all of the tags
+//	createdFrom/createdTo createdAt range, RFC 3339 or YYYY-MM-DD
+//	updatedFrom/updatedTo updatedAt range, RFC 3339 or YYYY-MM-DD
+//	title=text            title substring
+//	dueFrom/dueTo         due date range, RFC 3339 or YYYY-MM-DD
+//	tz=Europe/Berlin      time zone of due dates and of dates in dueFrom/dueTo
+//	sort=createdAt        importance, id, createdAt, updatedAt, status, title,
+//	                      dueAt or priority
+//	order=asc|desc        sort direction
+//	ready=true            todos with no open blockers
+//	withTags=false        return the bare todos without their tags
+func todoFilterFromRequest(r *http.Request) (TodoFilter, error) {
+	query := r.URL.Query()
+	var f TodoFilter
+
+	for _, v := range listParam(query["status"]) {
+		if id, err := strconv.Atoi(v); err == nil {
+			f.Statuses = append(f.Statuses, models.ToDoStatus(id))
+		} else {
+			f.StatusNames = append(f.StatusNames, v)
+		}
+	}
+
+	for _, v := range listParam(query["category"]) {
+		c := models.StatusCategory(strings.ToLower(v))
+		if !c.Valid() {
+			return f, errBadRequest{fmt.Errorf("category must be open, active or done, got %q", v)}
+		}
+		f.Categories = append(f.Categories, c)
+	}
+
+	if v := query.Get("parentId"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 1 {
+			return f, errBadRequest{fmt.Errorf("parentId must be a todo id, got %q", v)}
+		}
+		f.Parent = id
+	}
+
+	for _, v := range listParam(query["priority"]) {
+		p, err := models.ParsePriority(v)
+		if err != nil {
+			return f, errBadRequest{err}
+		}
+		f.Priorities = append(f.Priorities, p)
+	}
+
+	f.Tags = listParam(query["tag"])
+	switch f.TagMode = query.Get("tagMode"); f.TagMode {
+	case "", TagsAny, TagsAll:
+	default:
+		return f, errBadRequest{fmt.Errorf("tagMode must be %q or %q, got %q", TagsAny, TagsAll, f.TagMode)}
+	}
+
+	for _, p := range []struct {
+		name   string
+		target *time.Time
+	}{
+		{"createdFrom", &f.CreatedFrom},
+		{"createdTo", &f.CreatedTo},
+		{"updatedFrom", &f.UpdatedFrom},
+		{"updatedTo", &f.UpdatedTo},
+	} {
+		if v := query.Get(p.name); v != "" {
+			t, err := parseTime(v)
+			if err != nil {
+				return f, errBadRequest{fmt.Errorf("%s must be an RFC 3339 time or a YYYY-MM-DD date, got %q", p.name, v)}
+			}
+			*p.target = t
+		}
+	}
+
+	f.Title = query.Get("title")
+
+	if v := query.Get("tz"); v != "" {
+		loc, err := time.LoadLocation(v)
+		if err != nil {
+			return f, errBadRequest{fmt.Errorf("unknown time zone %q", v)}
+		}
+		f.Location = loc
+	}
+	for _, p := range []struct {
+		name   string
+		target *time.Time
+	}{
+		{"dueFrom", &f.DueFrom},
+		{"dueTo", &f.DueTo},
+	} {
+		if v := query.Get(p.name); v != "" {
+			t, err := parseTimeIn(v, f.location())
+			if err != nil {
+				return f, errBadRequest{fmt.Errorf("%s must be an RFC 3339 time or a YYYY-MM-DD date, got %q", p.name, v)}
+			}
+			*p.target = t
+		}
+	}
+
+	f.Sort = query.Get("sort")
+	if _, ok := todoSorts[f.Sort]; f.Sort != "" && !ok {
+		return f, errBadRequest{fmt.Errorf("cannot sort by %q", f.Sort)}
+	}
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		f.Desc = true
+	default:
+		return f, errBadRequest{fmt.Errorf("order must be asc or desc, got %q", order)}
+	}
+
+	switch v := query.Get("ready"); v {
+	case "", "false", "0":
+	case "true", "1":
+		f.Ready = true
+	default:
+		return f, errBadRequest{fmt.Errorf("ready must be true or false, got %q", v)}
+	}
+
+	switch v := query.Get("withTags"); v {
+	case "", "true", "1":
+	case "false", "0":
+		f.WithoutTags = true
+	default:
+		return f, errBadRequest{fmt.Errorf("withTags must be true or false, got %q", v)}
+	}
+
+	return f, nil
+}
+
+// listParam flattens repeated and comma separated query values
+func listParam(values []string) []string {
+	var items []string
+	for _, v := range values {
+		items = append(items, splitTrim(v)...)
+	}
+	return items
+}
+
+func splitTrim(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseTime accepts an RFC 3339 timestamp or a date, read as midnight UTC
+func parseTime(v string) (time.Time, error) {
+	return parseTimeIn(v, time.UTC)
+}
+
+// parseTimeIn is parseTime with dates read as midnight in loc
+func parseTimeIn(v string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(dateLayout, v, loc)
+}
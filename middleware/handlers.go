@@ -7,294 +7,936 @@ Based on tutorial from codesource.io - https://codesource.io/build-a-crud-applic
 */
 
 import (
-	"encoding/json" // package to encode and decode the json into struct and vice versa
 	"fmt"
-	"strconv"
-
-	"log"
 	"net/http" // used to access the request and response object of the api
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
-	// used to read the environment variable
-	// package used to covert string into int type
-
-	"github.com/gorilla/mux" // used to get the params from the route
-
-	_ "github.com/mattn/go-sqlite3" // sqlite3 driver
+	"github.com/gorilla/mux"
 
 	"go-todo/models" // models package where ToDo schema is defined
 )
 
+// Result limits for SuggestTags
+const (
+	DefaultSuggestLimit = 10
+	MaxSuggestLimit     = 50
+)
+
 // response format
 type response struct {
 	ID      int64  `json:"id,omitempty"`
 	Message string `json:"message,omitempty"`
 }
 
-// CreateTodo create a todo entry
-func CreateTodo(w http.ResponseWriter, r *http.Request) {
-	// set the header to content type x-www-form-urlencoded
-	// Allow all origin to handle cors issue
-	w.Header().Set("Context-Type", "application/x-www-form-urlencoded")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// Handler serves the API endpoints on top of a TodoStore
+type Handler struct {
+	store TodoStore
+}
+
+// NewHandler returns a Handler that reads and writes through store
+func NewHandler(store TodoStore) *Handler {
+	return &Handler{store: store}
+}
+
+// validateTodo checks the fields a client must supply for a todo
+func validateTodo(todo models.ToDo) error {
+	if strings.TrimSpace(todo.Title) == "" {
+		return invalidf("title must not be empty")
+	}
+	if !todo.Priority.Valid() {
+		return invalidf("priority must be one of 0 (None), 1 (Low), 2 (Medium), 3 (High) or 4 (Urgent)")
+	}
+	if todo.ParentID < 0 {
+		return invalidf("parentId must be a todo id")
+	}
+	if todo.DueAt != "" {
+		if _, _, err := parseDue(todo.DueAt); err != nil {
+			return err
+		}
+	}
+	if todo.Recurrence != "" {
+		if _, err := parseRecurrence(todo.Recurrence); err != nil {
+			return err
+		}
+		if todo.DueAt == "" {
+			return invalidf("a recurring todo needs a dueAt")
+		}
+	}
+	return nil
+}
+
+// validateStatus checks the fields a client must supply for a status
+func validateStatus(status models.Status) error {
+	if strings.TrimSpace(status.Name) == "" {
+		return invalidf("name must not be empty")
+	}
+	if !status.Category.Valid() {
+		return invalidf("category must be open, active or done")
+	}
+	return nil
+}
+
+// validateTag checks the fields a client must supply for a tag
+func validateTag(tag models.Tag) error {
+	if normalizeTagName(tag.Name) == "" {
+		return invalidf("name must not be empty")
+	}
+	return validateColor(tag.Color)
+}
+
+// tagColor matches the #rgb and #rrggbb hex colors accepted for tags
+var tagColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// validateColor accepts an empty color or a hex color such as #ff8800
+func validateColor(color string) error {
+	if color != "" && !tagColor.MatchString(color) {
+		return invalidf("color must be a hex color such as #ff8800, got %q", color)
+	}
+	return nil
+}
 
+// CreateTodo create a todo entry
+func (h *Handler) CreateTodo(w http.ResponseWriter, r *http.Request) {
 	// create an empty todo
 	var todo models.ToDo
 
 	// decode the json request to todo
-	err := json.NewDecoder(r.Body).Decode(&todo)
+	if err := decodeBody(r, &todo); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateTodo(todo); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	newTodo, err := h.store.InsertTodo(r.Context(), todo)
 	if err != nil {
-		log.Fatalf("Unable to decode the request body.  %v\n", err)
+		writeError(w, r, err)
+		return
 	}
 
-	newTodo, err := insertTodo(todo)
+	writeJSON(w, http.StatusOK, newTodo)
+}
+
+// GetTodo get a todo
+func (h *Handler) GetTodo(w http.ResponseWriter, r *http.Request) {
+	// get the todo id from the request params, key is "id"
+	id, err := pathID(r, "id")
 	if err != nil {
-		log.Fatalf("Error in inserting todo %v\n", err)
+		writeError(w, r, err)
+		return
 	}
 
-	err = json.NewEncoder(w).Encode(newTodo)
+	todo, err := h.store.GetTodo(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, r, err)
 		return
 	}
+
+	// send the response
+	writeJSON(w, http.StatusOK, todo)
 }
 
-// GetTodo get a todo
-func GetTodo(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Context-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+// GetAllTodos get a page of todos, see todoFilterFromRequest and
+// pageFromRequest for the query parameters
+func (h *Handler) GetAllTodos(w http.ResponseWriter, r *http.Request) {
+	filter, err := todoFilterFromRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	page, err := pageFromRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// get one page of matching todos from the db
+	todos, next, err := h.store.GetAllTodos(r.Context(), filter, page)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// send the page as response, the next page is linked in the headers
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, todos)
+}
+
+// GetChildTodos get a page of the direct subtasks of a todo. The query
+// parameters of GetAllTodos apply too.
+func (h *Handler) GetChildTodos(w http.ResponseWriter, r *http.Request) {
 	// get the todo id from the request params, key is "id"
-	params := mux.Vars(r)
-	// the id type from string to int
-	id, err := strconv.Atoi(params["id"])
-	checkErr(err)
-	todo, err := getTodo(int64(id))
-	// call the getUser function with user id to retrieve a single user
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	filter, err := todoFilterFromRequest(r)
 	if err != nil {
-		log.Fatalf("Unable to get todo. %v", err)
+		writeError(w, r, err)
+		return
+	}
+	filter.Parent = id
+	page, err := pageFromRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	// send the response
-	err = json.NewEncoder(w).Encode(todo)
+	// an unknown todo is a 404 rather than an empty list
+	if _, err := h.store.GetTodo(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	todos, next, err := h.store.GetAllTodos(r.Context(), filter, page)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, r, err)
 		return
 	}
+
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, todos)
 }
 
-// GetAllTodos get all todos
-func GetAllTodos(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Context-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	// get all the users in the db
-	todos, err := getAllTodos()
+// GetTodoSubtree get a todo followed by all of its subtasks, depth first.
+// Each carries its parentId so clients can rebuild the tree.
+func (h *Handler) GetTodoSubtree(w http.ResponseWriter, r *http.Request) {
+	// get the todo id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	todos, err := h.store.SubtreeTodos(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, todos)
+}
 
+// DueTodos get a page of the todos in the due date view named by the path:
+// overdue, today or upcoming, see dueView. The query parameters of
+// GetAllTodos apply too.
+func (h *Handler) DueTodos(w http.ResponseWriter, r *http.Request) {
+	filter, err := todoFilterFromRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := dueView(&filter, mux.Vars(r)["view"], r, time.Now()); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	page, err := pageFromRequest(r)
 	if err != nil {
-		log.Fatalf("Unable to get all todo. %v", err)
+		writeError(w, r, err)
+		return
 	}
 
-	// send all the users as response
-	err = json.NewEncoder(w).Encode(todos)
+	// get one page of matching todos from the db
+	todos, next, err := h.store.GetAllTodos(r.Context(), filter, page)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, r, err)
 		return
 	}
+
+	// send the page as response, the next page is linked in the headers
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, todos)
 }
 
-// UpdateTodo update a todo
-func UpdateTodo(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "PUT")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// SearchTodos full-text search over todo titles and descriptions. The q
+// parameter takes FTS5 query syntax: words, "exact phrases", prefix* terms
+// and AND/OR/NOT. Results are ranked best first; limit caps their number.
+func (h *Handler) SearchTodos(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeError(w, r, errBadRequest{fmt.Errorf("the q parameter is required")})
+		return
+	}
+	page, err := pageFromRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	results, err := h.store.SearchTodos(r.Context(), query, page.limit())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	// get the userid from the request params, key is "id"
-	params := mux.Vars(r)
-	// the id type from string to int
-	id, err := strconv.Atoi(params["id"])
-	checkErr(err)
-	var todo models.ToDo
+	writeJSON(w, http.StatusOK, results)
+}
+
+// UpdateTodo replace a todo, every field is overwritten
+func (h *Handler) UpdateTodo(w http.ResponseWriter, r *http.Request) {
+	// get the todo id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	// decode the json request to user
-	err = json.NewDecoder(r.Body).Decode(&todo)
+	var todo models.ToDo
 
+	// decode the json request to todo
+	if err := decodeBody(r, &todo); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateTodo(todo); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	force, err := forceParam(r)
 	if err != nil {
-		log.Fatalf("Unable to decode the request body.  %v", err)
+		writeError(w, r, err)
+		return
 	}
 
-	newTodo, err := updateTodo(int64(id), todo)
-	checkErr(err)
+	newTodo, err := h.store.UpdateTodo(r.Context(), id, todo, force)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	// send the response
-	err = json.NewEncoder(w).Encode(newTodo)
+	writeJSON(w, http.StatusOK, newTodo)
+}
+
+// forceParam reads the force query parameter, which lets a write start or
+// close a todo that still waits on open blockers
+func forceParam(r *http.Request) (bool, error) {
+	switch v := r.URL.Query().Get("force"); v {
+	case "", "false", "0":
+		return false, nil
+	case "true", "1":
+		return true, nil
+	default:
+		return false, errBadRequest{fmt.Errorf("force must be true or false, got %q", v)}
+	}
+}
+
+// PatchTodo partially update a todo. The body is a JSON Merge Patch
+// (application/merge-patch+json, also assumed for application/json) or a
+// JSON Patch (application/json-patch+json); only the fields it touches are
+// written.
+func (h *Handler) PatchTodo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+
+	// get the todo id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	mediaType, ok := patchMediaType(r)
+	if !ok {
+		writeProblem(w, r, http.StatusUnsupportedMediaType, fmt.Sprintf("PATCH accepts %s or %s", mergePatchType, jsonPatchType))
+		return
+	}
+
+	// JSON Patch operations such as test are evaluated against the current
+	// todo, and again by the store as it writes it
+	current := func() (interface{}, error) { return h.store.GetTodo(r.Context(), id) }
+
+	var patch TodoPatch
+	check, err := decodePatch(r, mediaType, current, patch.set)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if check != nil {
+		patch.Precondition = func(todo models.ToDo) error { return check(todo) }
+	}
+	if patch.Force, err = forceParam(r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	todo, err := h.store.PatchTodo(r.Context(), id, patch)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, r, err)
 		return
 	}
+
+	writeJSON(w, http.StatusOK, todo)
 }
 
 // DeleteTodo delete a todo
-func DeleteTodo(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Context-Type", "application/x-www-form-urlencoded")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	// get the userid from the request params, key is "id"
-	params := mux.Vars(r)
-	// the id type from string to int
-	id, err := strconv.Atoi(params["id"])
-	checkErr(err)
+func (h *Handler) DeleteTodo(w http.ResponseWriter, r *http.Request) {
+	// get the todo id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	deletedRows, err := deleteTodo(int64(id))
-	checkErr(err)
+	deletedRows, err := h.store.DeleteTodo(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	// format the message string
 	msg := fmt.Sprintf("Todo deleted successfully. Total rows/record affected %v", deletedRows)
 
-	// format the reponse message
-	res := response{
-		ID:      int64(id),
-		Message: msg,
+	// send the response
+	writeJSON(w, http.StatusOK, response{ID: id, Message: msg})
+}
+
+// AddTag will add a tag, or return the existing tag of the same name
+func (h *Handler) AddTag(w http.ResponseWriter, r *http.Request) {
+	// create an empty tag
+	var tag models.Tag
+
+	// decode the json request to tag
+	if err := decodeBody(r, &tag); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateTag(tag); err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	// send the response
-	err = json.NewEncoder(w).Encode(res)
+	newEntry, err := h.store.InsertTag(r.Context(), tag)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, r, err)
 		return
 	}
+
+	writeJSON(w, http.StatusOK, newEntry)
 }
 
-// AddTag will add a tag
-func AddTag(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Context-Type", "application/x-www-form-urlencoded")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// UpdateTag will replace the name, color and description of a tag
+func (h *Handler) UpdateTag(w http.ResponseWriter, r *http.Request) {
+	// get the tag id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	// create an empty tag
 	var tag models.Tag
 
 	// decode the json request to tag
-	err := json.NewDecoder(r.Body).Decode(&tag)
+	if err := decodeBody(r, &tag); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateTag(tag); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	newTag, err := h.store.UpdateTag(r.Context(), id, tag)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newTag)
+}
+
+// PatchTag will partially update a tag, accepting the same patch formats as
+// PatchTodo
+func (h *Handler) PatchTag(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+
+	// get the tag id from the request params, key is "id"
+	id, err := pathID(r, "id")
 	if err != nil {
-		log.Fatalf("Unable to decode the request body.  %v", err)
+		writeError(w, r, err)
+		return
+	}
+
+	mediaType, ok := patchMediaType(r)
+	if !ok {
+		writeProblem(w, r, http.StatusUnsupportedMediaType, fmt.Sprintf("PATCH accepts %s or %s", mergePatchType, jsonPatchType))
+		return
 	}
 
-	newEntry, err := insertTag(tag)
+	current := func() (interface{}, error) { return h.store.GetTag(r.Context(), id) }
+
+	var patch TagPatch
+	check, err := decodePatch(r, mediaType, current, patch.set)
 	if err != nil {
-		log.Fatalf("Error in inserting new tag. %v\n", err)
+		writeError(w, r, err)
+		return
+	}
+	if check != nil {
+		patch.Precondition = func(tag models.Tag) error { return check(tag) }
 	}
 
-	err = json.NewEncoder(w).Encode(newEntry)
+	tag, err := h.store.PatchTag(r.Context(), id, patch)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, r, err)
 		return
 	}
+
+	writeJSON(w, http.StatusOK, tag)
 }
 
 // DeleteTag will delete a tag
-func DeleteTag(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Context-Type", "application/x-www-form-urlencoded")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	// get the userid from the request params, key is "id"
-	params := mux.Vars(r)
-	// the id type from string to int
-	id, err := strconv.Atoi(params["id"])
-	checkErr(err)
-
-	deletedRows, err := deleteTag(int64(id))
-	checkErr(err)
+func (h *Handler) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	// get the tag id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	deletedRows, err := h.store.DeleteTag(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
 	// format the message string
 	msg := fmt.Sprintf("Tag deleted successfully. Total rows/record affected %v", deletedRows)
 
-	// format the reponse message
-	res := response{
-		ID:      int64(id),
-		Message: msg,
+	// send the response
+	writeJSON(w, http.StatusOK, response{ID: id, Message: msg})
+}
+
+// SubtreeTags will list a tag and the tags below it in its namespace, e.g.
+// team, team/backend and team/backend/api. The depth parameter limits how
+// many levels below the tag are listed.
+func (h *Handler) SubtreeTags(w http.ResponseWriter, r *http.Request) {
+	// get the tag id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	// send the response
-	err = json.NewEncoder(w).Encode(res)
+	depth := 0
+	if v := r.URL.Query().Get("depth"); v != "" {
+		if depth, err = strconv.Atoi(v); err != nil || depth < 1 {
+			writeError(w, r, errBadRequest{fmt.Errorf("depth must be a positive integer, got %q", v)})
+			return
+		}
+	}
+
+	tags, err := h.store.SubtreeTags(r.Context(), id, depth)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tags)
+}
+
+// MergeTags will merge tags into the tag in the path. The body lists the
+// source tags by id or name, e.g. {"sources": [4, "bugs"]}; they are deleted
+// and their todos carry the target tag instead.
+func (h *Handler) MergeTags(w http.ResponseWriter, r *http.Request) {
+	// get the target tag id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var body struct {
+		Sources TagRefs `json:"sources"`
+	}
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	merge, err := h.store.MergeTags(r.Context(), id, body.Sources)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, r, err)
 		return
 	}
+
+	writeJSON(w, http.StatusOK, merge)
 }
 
 // GetTag will get a tag
-func GetTag(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Context-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	// get the todo id from the request params, key is "id"
-	params := mux.Vars(r)
-	// the id type from string to int
-	id, err := strconv.Atoi(params["id"])
-	checkErr(err)
+func (h *Handler) GetTag(w http.ResponseWriter, r *http.Request) {
+	// get the tag id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	tag, err := getTag(int64(id))
+	tag, err := h.store.GetTag(r.Context(), id)
 	if err != nil {
-		log.Fatalf("Error in retrieving tag. %v\n", err)
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tag)
+}
+
+// LookupTag will get a tag by its name, given in the name query parameter
+func (h *Handler) LookupTag(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if strings.TrimSpace(name) == "" {
+		writeError(w, r, errBadRequest{fmt.Errorf("name is required")})
+		return
 	}
 
-	err = json.NewEncoder(w).Encode(tag)
+	tag, err := h.store.GetTagByName(r.Context(), name)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, r, err)
 		return
 	}
+
+	writeJSON(w, http.StatusOK, tag)
 }
 
-// GetAllTags list all tags
-func GetAllTags(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Context-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	// get all the users in the db
-	tags, err := getAllTags()
+// SuggestTags will complete a tag name for autocomplete. Tags whose name or
+// a namespace segment starts with the prefix parameter are ranked by usage
+// count, then by most recent use; limit caps their number.
+func (h *Handler) SuggestTags(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultSuggestLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxSuggestLimit {
+			writeError(w, r, errBadRequest{fmt.Errorf("limit must be an integer between 1 and %d, got %q", MaxSuggestLimit, v)})
+			return
+		}
+		limit = n
+	}
+
+	tags, err := h.store.SuggestTags(r.Context(), r.URL.Query().Get("prefix"), limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tags)
+}
 
+// GetAllTags list a page of tags with their usage count, see pageFromRequest
+// for the query parameters
+func (h *Handler) GetAllTags(w http.ResponseWriter, r *http.Request) {
+	page, err := pageFromRequest(r)
 	if err != nil {
-		log.Fatalf("Unable to get all tags. %v", err)
+		writeError(w, r, err)
+		return
 	}
 
-	// send all the users as response
-	err = json.NewEncoder(w).Encode(tags)
+	// get one page of tags from the db
+	tags, next, err := h.store.GetAllTags(r.Context(), page)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, r, err)
 		return
 	}
+
+	// send the page as response, the next page is linked in the headers
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, tags)
 }
 
 // AssociateTag will associate a tag with a todo
-func AssociateTag(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Context-Type", "application/x-www-form-urlencoded")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	// get the todo id from the request params, key is "id"
-	params := mux.Vars(r)
-	// the id type from string to int
-	tagID, err := strconv.Atoi(params["tagID"])
-	checkErr(err)
-	todoID, err := strconv.Atoi(params["todoID"])
-	checkErr(err)
-
-	associationID, err := associateTag(int64(tagID), int64(todoID))
-	checkErr(err)
+func (h *Handler) AssociateTag(w http.ResponseWriter, r *http.Request) {
+	// get the tag and todo ids from the request params
+	tagID, err := pathID(r, "tagID")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	todoID, err := pathID(r, "todoID")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	associationID, err := h.store.AssociateTag(r.Context(), tagID, todoID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 	msg := fmt.Sprintf("Tag associated successfully. todos_tags ID: %v", associationID)
 
-	// format the reponse message
-	res := response{
-		ID:      int64(associationID),
-		Message: msg,
+	// send the response
+	writeJSON(w, http.StatusOK, response{ID: associationID, Message: msg})
+}
+
+// DissociateTag will remove a tag from a todo
+func (h *Handler) DissociateTag(w http.ResponseWriter, r *http.Request) {
+	// get the tag and todo ids from the request params
+	tagID, err := pathID(r, "tagID")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	todoID, err := pathID(r, "todoID")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := h.store.DissociateTag(r.Context(), tagID, todoID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	msg := fmt.Sprintf("Tag %v removed from todo %v", tagID, todoID)
+
+	// send the response
+	writeJSON(w, http.StatusOK, response{Message: msg})
+}
+
+// ReplaceTodoTags replace the whole tag set of a todo. The body is a JSON
+// array of tag ids and names, e.g. [3, "urgent"]; an empty array clears it.
+func (h *Handler) ReplaceTodoTags(w http.ResponseWriter, r *http.Request) {
+	// get the todo id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var refs TagRefs
+	if err := decodeBody(r, &refs); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tags, err := h.store.ReplaceTags(r.Context(), id, refs)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if tags == nil {
+		tags = []models.Tag{}
 	}
 
 	// send the response
-	err = json.NewEncoder(w).Encode(res)
+	writeJSON(w, http.StatusOK, tags)
+}
+
+// GetTodoHistory get the status changes of a todo, oldest first
+func (h *Handler) GetTodoHistory(w http.ResponseWriter, r *http.Request) {
+	// get the todo id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	changes, err := h.store.StatusHistory(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// GetOccurrences preview the due dates of the next occurrences of a
+// recurring todo; limit picks how many, DefaultOccurrences unless given
+func (h *Handler) GetOccurrences(w http.ResponseWriter, r *http.Request) {
+	// get the todo id from the request params, key is "id"
+	id, err := pathID(r, "id")
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, r, err)
 		return
 	}
+	limit := DefaultOccurrences
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxOccurrences {
+			writeError(w, r, errBadRequest{fmt.Errorf("limit must be an integer between 1 and %d, got %q", MaxOccurrences, v)})
+			return
+		}
+		limit = n
+	}
+
+	dates, err := h.store.Occurrences(r.Context(), id, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, dates)
+}
+
+// GetBlockers get the todos a todo waits on, open or Closed
+func (h *Handler) GetBlockers(w http.ResponseWriter, r *http.Request) {
+	// get the todo id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	todos, err := h.store.GetBlockers(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// send the response
+	writeJSON(w, http.StatusOK, todos)
+}
+
+// AddBlocker will make a todo wait on another one
+func (h *Handler) AddBlocker(w http.ResponseWriter, r *http.Request) {
+	// get the todo and blocker ids from the request params
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	blockerID, err := pathID(r, "blockerID")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := h.store.AddBlocker(r.Context(), id, blockerID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	msg := fmt.Sprintf("Todo %v is now blocked by todo %v", id, blockerID)
+
+	// send the response
+	writeJSON(w, http.StatusOK, response{ID: id, Message: msg})
+}
+
+// RemoveBlocker will stop a todo from waiting on another one
+func (h *Handler) RemoveBlocker(w http.ResponseWriter, r *http.Request) {
+	// get the todo and blocker ids from the request params
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	blockerID, err := pathID(r, "blockerID")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := h.store.RemoveBlocker(r.Context(), id, blockerID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	msg := fmt.Sprintf("Todo %v is no longer blocked by todo %v", id, blockerID)
+
+	// send the response
+	writeJSON(w, http.StatusOK, response{ID: id, Message: msg})
+}
+
+// GetAllStatuses list every status in board order
+func (h *Handler) GetAllStatuses(w http.ResponseWriter, r *http.Request) {
+	statuses, err := h.store.GetAllStatuses(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// GetStatus get a single status by its id
+func (h *Handler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	// get the status id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	status, err := h.store.GetStatus(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// AddStatus create a status; without an order it goes after the others
+func (h *Handler) AddStatus(w http.ResponseWriter, r *http.Request) {
+	var status models.Status
+
+	// decode the json request to status
+	if err := decodeBody(r, &status); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateStatus(status); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	newEntry, err := h.store.InsertStatus(r.Context(), status)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newEntry)
+}
+
+// UpdateStatus replace the name, order and category of a status
+func (h *Handler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
+	// get the status id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var status models.Status
+
+	// decode the json request to status
+	if err := decodeBody(r, &status); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateStatus(status); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	newStatus, err := h.store.UpdateStatus(r.Context(), id, status)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newStatus)
+}
+
+// DeleteStatus delete a status that no todo is in
+func (h *Handler) DeleteStatus(w http.ResponseWriter, r *http.Request) {
+	// get the status id from the request params, key is "id"
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	deletedRows, err := h.store.DeleteStatus(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// format the message string
+	msg := fmt.Sprintf("Status deleted successfully. Total rows/record affected %v", deletedRows)
+
+	// send the response
+	writeJSON(w, http.StatusOK, response{ID: id, Message: msg})
 }
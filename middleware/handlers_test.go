@@ -0,0 +1,428 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"go-todo/models"
+)
+
+func TestGetAllTagsLinkHeader(t *testing.T) {
+	for _, name := range []string{"page one", "page two", "page three"} {
+		if _, err := testStore.InsertTag(ctx, models.Tag{Name: name}); err != nil {
+			t.Fatal("Error inserting tag", err)
+		}
+	}
+	h := NewHandler(testStore)
+
+	rec := serve(h.GetAllTags, "GET", "/api/tag", "/api/tag?limit=1", "")
+	if rec.Code != http.StatusOK {
+		t.Fatal("Unexpected status", rec.Code)
+	}
+
+	link := rec.Header().Get("Link")
+	if !strings.HasSuffix(link, `>; rel="next"`) {
+		t.Fatal("Missing next link", link)
+	}
+	next, err := url.Parse(strings.TrimPrefix(strings.SplitN(link, ">", 2)[0], "<"))
+	if err != nil {
+		t.Fatal("Next link is not a URL", link)
+	}
+	if next.Query().Get("limit") != "1" || next.Query().Get("cursor") != rec.Header().Get("X-Next-Cursor") {
+		t.Error("Next link does not carry the limit and cursor", link)
+	}
+
+	rec = serve(h.GetAllTags, "GET", "/api/tag", "/api/tag?limit=0", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Error("Expected 400 for an out of range limit", rec.Code)
+	}
+}
+
+func patchTodo(h *Handler, id int64, contentType, body string) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	router.HandleFunc("/api/todo/{id}", h.PatchTodo).Methods("PATCH")
+
+	req := httptest.NewRequest("PATCH", fmt.Sprintf("/api/todo/%d", id), strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPatchTodoMergePatch(t *testing.T) {
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "merge patched", Description: "keep me"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	h := NewHandler(testStore)
+
+	rec := patchTodo(h, todo.ID, "application/merge-patch+json", `{"status": 2}`)
+	if rec.Code != http.StatusOK {
+		t.Fatal("Unexpected status", rec.Code, rec.Body.String())
+	}
+
+	patched, err := testStore.GetTodo(ctx, todo.ID)
+	if err != nil {
+		t.Fatal("Error retrieving todo", err)
+	}
+	if patched.Status != models.Closed {
+		t.Error("Status was not patched", patched)
+	}
+	if patched.Title != todo.Title || patched.Description != todo.Description {
+		t.Error("Fields missing from the patch were overwritten", patched)
+	}
+
+	// null removes the description
+	if rec := patchTodo(h, todo.ID, "application/json", `{"description": null}`); rec.Code != http.StatusOK {
+		t.Error("Unexpected status", rec.Code, rec.Body.String())
+	}
+	if patched, _ = testStore.GetTodo(ctx, todo.ID); patched.Description != "" {
+		t.Error("Description was not removed", patched)
+	}
+
+	if rec := patchTodo(h, todo.ID, "application/merge-patch+json", `{"createdAt": "2020-01-01T00:00:00Z"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 patching a read-only field", rec.Code)
+	}
+	if rec := patchTodo(h, todo.ID, "application/merge-patch+json", `{"status": 10}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 patching an unknown status", rec.Code)
+	}
+	if rec := patchTodo(h, todo.ID, "text/plain", `{}`); rec.Code != http.StatusUnsupportedMediaType {
+		t.Error("Expected 415 for an unsupported patch format", rec.Code)
+	}
+	if rec := patchTodo(h, 999999, "application/merge-patch+json", `{"status": 1}`); rec.Code != http.StatusNotFound {
+		t.Error("Expected 404 patching an unknown todo", rec.Code)
+	}
+}
+
+func TestPatchTodoJSONPatch(t *testing.T) {
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "json patched", Description: "before"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	h := NewHandler(testStore)
+
+	rec := patchTodo(h, todo.ID, "application/json-patch+json", `[
+		{"op": "test", "path": "/description", "value": "before"},
+		{"op": "replace", "path": "/description", "value": "after"},
+		{"op": "copy", "from": "/description", "path": "/title"}
+	]`)
+	if rec.Code != http.StatusOK {
+		t.Fatal("Unexpected status", rec.Code, rec.Body.String())
+	}
+
+	patched, err := testStore.GetTodo(ctx, todo.ID)
+	if err != nil {
+		t.Fatal("Error retrieving todo", err)
+	}
+	if patched.Title != "after" || patched.Description != "after" || patched.Status != models.Open {
+		t.Error("Patch was not applied as expected", patched)
+	}
+
+	rec = patchTodo(h, todo.ID, "application/json-patch+json", `[
+		{"op": "test", "path": "/description", "value": "before"},
+		{"op": "replace", "path": "/title", "value": "never"}
+	]`)
+	if rec.Code != http.StatusConflict {
+		t.Error("Expected 409 for a failed test operation", rec.Code)
+	}
+	if patched, _ = testStore.GetTodo(ctx, todo.ID); patched.Title != "after" {
+		t.Error("A failed patch changed the todo", patched)
+	}
+
+	if rec := patchTodo(h, todo.ID, "application/json-patch+json", `[{"op": "remove", "path": "/title"}]`); rec.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 removing the title", rec.Code)
+	}
+	if rec := patchTodo(h, todo.ID, "application/json-patch+json", `[{"op": "add", "path": "/tags/0", "value": 1}]`); rec.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 for a nested path", rec.Code)
+	}
+}
+
+// racingStore changes a todo right after the handler has read it, as a
+// concurrent request would
+type racingStore struct {
+	*SQLiteStore
+	race func(id int64)
+}
+
+func (s racingStore) GetTodo(ctx context.Context, id int64) (models.ToDo, error) {
+	todo, err := s.SQLiteStore.GetTodo(ctx, id)
+	s.race(id)
+	return todo, err
+}
+
+func TestPatchTodoJSONPatchRace(t *testing.T) {
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "raced", Description: "before"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	races := 0
+	h := NewHandler(racingStore{testStore, func(id int64) {
+		races++
+		description := fmt.Sprintf("meanwhile %d", races)
+		if _, err := testStore.PatchTodo(ctx, id, TodoPatch{Description: &description}); err != nil {
+			t.Fatal("Error changing todo", err)
+		}
+	}})
+
+	rec := patchTodo(h, todo.ID, "application/json-patch+json", `[
+		{"op": "test", "path": "/description", "value": "before"},
+		{"op": "replace", "path": "/title", "value": "never"}
+	]`)
+	if rec.Code != http.StatusConflict {
+		t.Error("Expected 409 when the todo changed after the test", rec.Code)
+	}
+	if patched, _ := testStore.GetTodo(ctx, todo.ID); patched.Title != "raced" {
+		t.Error("A patch whose test no longer holds changed the todo", patched)
+	}
+
+	// a copy of a changed member is not written from the stale read
+	rec = patchTodo(h, todo.ID, "application/json-patch+json", `[{"op": "copy", "from": "/description", "path": "/title"}]`)
+	if rec.Code != http.StatusConflict {
+		t.Error("Expected 409 copying a member that changed", rec.Code)
+	}
+}
+
+func TestReplaceTodoTags(t *testing.T) {
+	tag, err := testStore.InsertTag(ctx, models.Tag{Name: "put tags"})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "put tags"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	h := NewHandler(testStore)
+	target := fmt.Sprintf("/api/todo/%d/tags", todo.ID)
+
+	rec := serve(h.ReplaceTodoTags, "PUT", "/api/todo/{id}/tags", target, fmt.Sprintf(`[%d, "put tags"]`, tag.ID))
+	if rec.Code != http.StatusOK {
+		t.Fatal("Unexpected status", rec.Code, rec.Body)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `"put tags"`) {
+		t.Error("Response does not list the tag", body)
+	}
+
+	rec = serve(h.ReplaceTodoTags, "PUT", "/api/todo/{id}/tags", target, `[true]`)
+	if rec.Code != http.StatusBadRequest {
+		t.Error("Expected 400 for a malformed tag list", rec.Code)
+	}
+	rec = serve(h.ReplaceTodoTags, "PUT", "/api/todo/{id}/tags", target, `["missing tag"]`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 for an unknown tag", rec.Code)
+	}
+}
+
+func TestLookupTag(t *testing.T) {
+	tag, err := testStore.InsertTag(ctx, models.Tag{Name: "looked up"})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	h := NewHandler(testStore)
+
+	rec := serve(h.LookupTag, "GET", "/api/tag/lookup", "/api/tag/lookup?name=Looked+Up", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), fmt.Sprintf(`"id":%d`, tag.ID)) {
+		t.Error("Lookup did not return the tag", rec.Code, rec.Body)
+	}
+
+	rec = serve(h.LookupTag, "GET", "/api/tag/lookup", "/api/tag/lookup?name=missing+lookup", "")
+	if rec.Code != http.StatusNotFound {
+		t.Error("Expected 404 for an unknown name", rec.Code)
+	}
+	rec = serve(h.LookupTag, "GET", "/api/tag/lookup", "/api/tag/lookup", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Error("Expected 400 without a name", rec.Code)
+	}
+}
+
+func TestPatchTag(t *testing.T) {
+	tag, err := testStore.InsertTag(ctx, models.Tag{Name: "patched tag"})
+	if err != nil {
+		t.Fatal("Error inserting tag", err)
+	}
+	h := NewHandler(testStore)
+	target := fmt.Sprintf("/api/tag/%d", tag.ID)
+
+	rec := serve(h.PatchTag, "PATCH", "/api/tag/{id}", target, `{"color": "#00aa00", "description": "green"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatal("Unexpected status", rec.Code, rec.Body)
+	}
+	if got, _ := testStore.GetTag(ctx, tag.ID); got.Color != "#00aa00" || got.Description != "green" || got.Name != "patched tag" {
+		t.Error("Unexpected tag after patch", got)
+	}
+
+	rec = serve(h.PatchTag, "PATCH", "/api/tag/{id}", target, `{"color": "green"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 for a malformed color", rec.Code)
+	}
+	rec = serve(h.PatchTag, "PATCH", "/api/tag/{id}", target, `{"id": 7}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 for a read-only field", rec.Code)
+	}
+}
+
+func TestTodoDueAt(t *testing.T) {
+	h := NewHandler(testStore)
+
+	rec := serve(h.CreateTodo, "POST", "/api/todo", "/api/todo", `{"title": "due soon", "dueAt": "next tuesday"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 for a malformed due date", rec.Code)
+	}
+
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "due soon", DueAt: "2030-01-02"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	rec = patchTodo(h, todo.ID, mergePatchType, `{"dueAt": null}`)
+	if rec.Code != http.StatusOK {
+		t.Fatal("Unexpected status", rec.Code, rec.Body)
+	}
+	if got, _ := testStore.GetTodo(ctx, todo.ID); got.DueAt != "" {
+		t.Error("Due date was not removed", got.DueAt)
+	}
+
+	rec = serve(h.DueTodos, "GET", "/api/todo/{view}", "/api/todo/upcoming?days=-1", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Error("Expected 400 for negative days", rec.Code)
+	}
+}
+
+func TestBlockedUpdate(t *testing.T) {
+	h := NewHandler(testStore)
+
+	blocker, err := testStore.InsertTodo(ctx, models.ToDo{Title: "handler blocker"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "handler blocked"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+
+	target := fmt.Sprintf("/api/todo/%d/blockers/%d", todo.ID, blocker.ID)
+	if rec := serve(h.AddBlocker, "POST", "/api/todo/{id}/blockers/{blockerID}", target, ""); rec.Code != http.StatusOK {
+		t.Fatal("Unexpected status", rec.Code, rec.Body)
+	}
+	target = fmt.Sprintf("/api/todo/%d/blockers/%d", blocker.ID, todo.ID)
+	if rec := serve(h.AddBlocker, "POST", "/api/todo/{id}/blockers/{blockerID}", target, ""); rec.Code != http.StatusConflict {
+		t.Error("Expected 409 for a cycle", rec.Code)
+	}
+
+	target = fmt.Sprintf("/api/todo/%d", todo.ID)
+	body := `{"title": "handler blocked", "status": 2}`
+	if rec := serve(h.UpdateTodo, "PUT", "/api/todo/{id}", target, body); rec.Code != http.StatusConflict {
+		t.Error("Expected 409 closing a blocked todo", rec.Code)
+	}
+	if rec := serve(h.UpdateTodo, "PUT", "/api/todo/{id}", target+"?force=maybe", body); rec.Code != http.StatusBadRequest {
+		t.Error("Expected 400 for a malformed force", rec.Code)
+	}
+	if rec := serve(h.UpdateTodo, "PUT", "/api/todo/{id}", target+"?force=true", body); rec.Code != http.StatusOK {
+		t.Error("Forced close failed", rec.Code, rec.Body)
+	}
+}
+
+func TestTodoOccurrences(t *testing.T) {
+	h := NewHandler(testStore)
+
+	rec := serve(h.CreateTodo, "POST", "/api/todo", "/api/todo", `{"title": "recurring", "recurrence": "FREQ=DAILY"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 for a recurring todo without dueAt", rec.Code)
+	}
+
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "recurring", DueAt: "2026-10-19T09:00:00Z", Recurrence: "FREQ=MONTHLY;INTERVAL=3"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	target := fmt.Sprintf("/api/todo/%d/occurrences?limit=2", todo.ID)
+	rec = serve(h.GetOccurrences, "GET", "/api/todo/{id}/occurrences", target, "")
+	if rec.Code != http.StatusOK {
+		t.Fatal("Unexpected status", rec.Code, rec.Body)
+	}
+	var dates []string
+	if err := json.NewDecoder(rec.Body).Decode(&dates); err != nil {
+		t.Fatal("Unable to decode the response", err)
+	}
+	if strings.Join(dates, ",") != "2027-01-19T09:00:00Z,2027-04-19T09:00:00Z" {
+		t.Error("Unexpected occurrences", dates)
+	}
+
+	rec = patchTodo(h, todo.ID, mergePatchType, `{"recurrence": "FREQ=FORTNIGHTLY"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 for an unsupported rule", rec.Code)
+	}
+	rec = patchTodo(h, todo.ID, mergePatchType, `{"dueAt": null}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 removing the due date of a recurring todo", rec.Code)
+	}
+}
+
+func TestTodoHistory(t *testing.T) {
+	h := NewHandler(testStore)
+
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "history"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	if rec := patchTodo(h, todo.ID, mergePatchType, `{"status": 2}`); rec.Code != http.StatusOK {
+		t.Fatal("Unexpected status", rec.Code, rec.Body)
+	}
+	if rec := patchTodo(h, todo.ID, mergePatchType, `{"status": 1}`); rec.Code != http.StatusConflict {
+		t.Error("Expected 409 for a transition the workflow refuses", rec.Code)
+	}
+
+	target := fmt.Sprintf("/api/todo/%d/history", todo.ID)
+	rec := serve(h.GetTodoHistory, "GET", "/api/todo/{id}/history", target, "")
+	if rec.Code != http.StatusOK {
+		t.Fatal("Unexpected status", rec.Code, rec.Body)
+	}
+	var history []models.StatusChange
+	if err := json.NewDecoder(rec.Body).Decode(&history); err != nil {
+		t.Fatal("Unable to decode the response", err)
+	}
+	if len(history) != 1 || history[0].From != models.Open || history[0].To != models.Closed {
+		t.Error("Unexpected history", history)
+	}
+}
+
+func TestStatusEndpoints(t *testing.T) {
+	h := NewHandler(testStore)
+
+	rec := serve(h.AddStatus, "POST", "/api/status", "/api/status", `{"name": "Handler Blocked", "category": "stuck"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 for an unknown category", rec.Code)
+	}
+	rec = serve(h.AddStatus, "POST", "/api/status", "/api/status", `{"name": "Handler Blocked", "category": "active"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatal("Unexpected status", rec.Code, rec.Body)
+	}
+	var status models.Status
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal("Unable to decode the response", err)
+	}
+
+	todo, err := testStore.InsertTodo(ctx, models.ToDo{Title: "handler status"})
+	if err != nil {
+		t.Fatal("Error in adding new Todo", err)
+	}
+	if rec := patchTodo(h, todo.ID, mergePatchType, fmt.Sprintf(`{"status": %d}`, status.ID)); rec.Code != http.StatusOK {
+		t.Error("Unable to move a todo to a custom status", rec.Code, rec.Body)
+	}
+	if rec := patchTodo(h, todo.ID, mergePatchType, `{"status": 999999}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Error("Expected 422 for an unknown status", rec.Code)
+	}
+
+	target := fmt.Sprintf("/api/status/%d", status.ID)
+	if rec := serve(h.DeleteStatus, "DELETE", "/api/status/{id}", target, ""); rec.Code != http.StatusConflict {
+		t.Error("Expected 409 deleting a status in use", rec.Code)
+	}
+	if rec := serve(h.GetStatus, "GET", "/api/status/{id}", "/api/status/999999", ""); rec.Code != http.StatusNotFound {
+		t.Error("Expected 404 for an unknown status", rec.Code)
+	}
+}
@@ -0,0 +1,286 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration file names look like 0002_add_due_date.up.sql
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// an up file starting with "-- requires: fts5" is only applied when sqlite
+// was compiled with SQLITE_ENABLE_FTS5
+var migrationRequires = regexp.MustCompile(`^--\s*requires:\s*(\w+)`)
+
+type migration struct {
+	version  int
+	name     string
+	up       string
+	down     string
+	requires string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt string
+	// Requires names the sqlite feature the migration depends on, if any
+	Requires string
+	// Supported is false when the running sqlite lacks Requires
+	Supported bool
+}
+
+// Migrator applies and rolls back the embedded schema migrations. The
+// current state is tracked in the schema_version table.
+type Migrator struct {
+	db         *sql.DB
+	migrations []migration
+}
+
+// NewMigrator loads the embedded migrations for db
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrations: unexpected file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+
+		body, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		} else if m.name != match[2] {
+			return nil, fmt.Errorf("migrations: version %d has two names, %q and %q", version, m.name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.up = string(body)
+			if req := migrationRequires.FindStringSubmatch(m.up); req != nil {
+				m.requires = req[1]
+			}
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migrations: version %d needs both an up and a down file", m.version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migrations: expected version %d, found %d", i+1, m.version)
+		}
+	}
+	return migrations, nil
+}
+
+func (m *Migrator) ensureVersionTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_version (version INTEGER PRIMARY KEY, name TEXT, appliedAt TIMESTAMP DEFAULT (strftime('%s', 'now')))")
+	return err
+}
+
+// Latest returns the highest known migration version
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Version returns the highest applied schema version, 0 for an empty database
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := m.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// applied returns the applied versions and when they were applied
+func (m *Migrator) applied(ctx context.Context) (map[int]string, error) {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.QueryContext(ctx, "SELECT version, appliedAt FROM schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]string{}
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// supports reports whether sqlite was compiled with the named feature
+func (m *Migrator) supports(ctx context.Context, feature string) (bool, error) {
+	if feature == "" {
+		return true, nil
+	}
+	var used bool
+	err := m.db.QueryRowContext(ctx, "SELECT sqlite_compileoption_used(?)", "ENABLE_"+strings.ToUpper(feature)).Scan(&used)
+	return used, err
+}
+
+// Status lists every known migration and whether it is applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		supported, err := m.supports(ctx, mig.requires)
+		if err != nil {
+			return nil, err
+		}
+		appliedAt, ok := applied[mig.version]
+		status = append(status, MigrationStatus{Version: mig.version, Name: mig.name, Applied: ok, AppliedAt: appliedAt, Requires: mig.requires, Supported: supported})
+	}
+	return status, nil
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.MigrateTo(ctx, m.Latest())
+}
+
+// Rollback reverts the last steps applied migrations
+func (m *Migrator) Rollback(ctx context.Context, steps int) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the target is the newest applied version that survives the rollback
+	target := 0
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[m.migrations[i].version]; !ok {
+			continue
+		}
+		if steps == 0 {
+			target = m.migrations[i].version
+			break
+		}
+		steps--
+	}
+	return m.MigrateTo(ctx, target)
+}
+
+// MigrateTo moves the schema up or down to the target version. Every
+// migration runs in its own transaction together with its schema_version
+// bookkeeping. Migrations whose required sqlite feature is missing are
+// skipped with a warning and picked up by a later run that has it.
+func (m *Migrator) MigrateTo(ctx context.Context, target int) error {
+	if target < 0 || target > m.Latest() {
+		return fmt.Errorf("migrations: target version %d out of range 0..%d", target, m.Latest())
+	}
+
+	current, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if current > m.Latest() {
+		return fmt.Errorf("migrations: database is at version %d but only %d migrations are known", current, m.Latest())
+	}
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	// roll back newest first
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.version]; !ok || mig.version <= target {
+			continue
+		}
+		if err := m.apply(ctx, mig.down, "DELETE FROM schema_version WHERE version=?", mig.version); err != nil {
+			return fmt.Errorf("migrations: rolling back %04d_%s: %w", mig.version, mig.name, err)
+		}
+		slog.Info("rolled back migration", "version", mig.version, "name", mig.name)
+	}
+
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.version]; ok || mig.version > target {
+			continue
+		}
+		supported, err := m.supports(ctx, mig.requires)
+		if err != nil {
+			return err
+		}
+		if !supported {
+			slog.Warn("skipping migration, sqlite lacks a required feature", "version", mig.version, "name", mig.name, "requires", mig.requires)
+			continue
+		}
+		if err := m.apply(ctx, mig.up, "INSERT INTO schema_version (version, name) VALUES (?, ?)", mig.version, mig.name); err != nil {
+			return fmt.Errorf("migrations: applying %04d_%s: %w", mig.version, mig.name, err)
+		}
+		slog.Info("applied migration", "version", mig.version, "name", mig.name)
+	}
+
+	return nil
+}
+
+func (m *Migrator) apply(ctx context.Context, script string, bookkeeping string, args ...interface{}) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
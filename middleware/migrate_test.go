@@ -0,0 +1,287 @@
+package middleware
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateUpAndDown(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "migrate.db"))
+	if err != nil {
+		t.Fatal("Cannot open database", err)
+	}
+	defer db.Close()
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal("Cannot load migrations", err)
+	}
+
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal("Error applying migrations", err)
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		t.Error("Error reading migration status", err)
+	}
+	newest := 0
+	for _, s := range status {
+		// migrations needing a missing sqlite feature are skipped
+		if s.Applied != s.Supported {
+			t.Error("Migration applied state does not match its support", s)
+		}
+		if s.Applied {
+			newest = s.Version
+		}
+	}
+
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		t.Error("Error reading schema version", err)
+	}
+	if version != newest {
+		t.Error("Schema version is not the newest applied migration", version, newest)
+	}
+
+	if err := migrator.Rollback(ctx, 1); err != nil {
+		t.Fatal("Error rolling back one migration", err)
+	}
+	if status, _ := migrator.Status(ctx); status[newest-1].Applied {
+		t.Error("Rollback left the newest migration applied", status[newest-1])
+	}
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal("Error re-applying migrations", err)
+	}
+
+	if err := migrator.MigrateTo(ctx, 0); err != nil {
+		t.Fatal("Error rolling back migrations", err)
+	}
+
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='todos'").Scan(&tables); err != nil {
+		t.Error("Error inspecting schema", err)
+	}
+	if tables != 0 {
+		t.Error("todos table still exists after rolling back everything")
+	}
+
+	// a fully rolled back database can be migrated again
+	if err := migrator.Up(ctx); err != nil {
+		t.Error("Error re-applying migrations", err)
+	}
+}
+
+func TestMigrateAdoptsExistingSchema(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatal("Cannot open database", err)
+	}
+	defer db.Close()
+
+	// a database created before migrations existed
+	if _, err := db.Exec("CREATE TABLE todos (id INTEGER PRIMARY KEY, title TEXT, description TEXT, createdAt TIMESTAMP default (strftime('%s', 'now')), updatedAt TIMESTAMP DEFAULT (strftime('%s', 'now')), status INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO todos (title, description, status) VALUES ('legacy', '', 0)"); err != nil {
+		t.Fatal(err)
+	}
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal("Cannot load migrations", err)
+	}
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal("Error migrating legacy database", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM todos").Scan(&count); err != nil {
+		t.Error("Error counting todos", err)
+	}
+	if count != 1 {
+		t.Error("Legacy rows were lost during migration", count)
+	}
+}
+
+func TestMigrateRemovesOrphanedAssociations(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "orphans.db"))
+	if err != nil {
+		t.Fatal("Cannot open database", err)
+	}
+	defer db.Close()
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal("Cannot load migrations", err)
+	}
+	if err := migrator.MigrateTo(ctx, 3); err != nil {
+		t.Fatal("Error migrating to the schema without foreign keys", err)
+	}
+
+	for _, stmt := range []string{
+		"INSERT INTO todos (id, title, status) VALUES (1, 'kept', 0)",
+		"INSERT INTO tags (id, name) VALUES (1, 'kept')",
+		"INSERT INTO todos_tags (todo_id, tag_id) VALUES (1, 1)",
+		"INSERT INTO todos_tags (todo_id, tag_id) VALUES (2, 1)",
+		"INSERT INTO todos_tags (todo_id, tag_id) VALUES (1, 2)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal("Error applying migrations", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM todos_tags").Scan(&count); err != nil {
+		t.Error("Error counting associations", err)
+	}
+	if count != 1 {
+		t.Error("Orphaned associations survived the migration", count)
+	}
+	if _, err := db.Exec("INSERT INTO todos_tags (todo_id, tag_id) VALUES (3, 1)"); err == nil {
+		t.Error("Foreign keys are not enforced")
+	}
+}
+
+func TestMigrateFoldsDuplicateTagNames(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "duplicates.db"))
+	if err != nil {
+		t.Fatal("Cannot open database", err)
+	}
+	defer db.Close()
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal("Cannot load migrations", err)
+	}
+	if err := migrator.MigrateTo(ctx, 4); err != nil {
+		t.Fatal("Error migrating to the schema without unique tag names", err)
+	}
+
+	for _, stmt := range []string{
+		"INSERT INTO todos (id, title, status) VALUES (1, 'first', 0), (2, 'second', 0)",
+		"INSERT INTO tags (id, name) VALUES (1, 'urgent'), (2, ' Urgent'), (3, 'URGENT ')",
+		"INSERT INTO todos_tags (todo_id, tag_id) VALUES (1, 1), (1, 2), (2, 3)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal("Error applying migrations", err)
+	}
+
+	var tags, associations int
+	if err := db.QueryRow("SELECT COUNT(*) FROM tags").Scan(&tags); err != nil {
+		t.Error("Error counting tags", err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM todos_tags WHERE tag_id=1").Scan(&associations); err != nil {
+		t.Error("Error counting associations", err)
+	}
+	if tags != 1 || associations != 2 {
+		t.Error("Duplicate tags were not folded into the oldest", tags, associations)
+	}
+	if _, err := db.Exec("INSERT INTO tags (name, nameKey) VALUES ('Urgent', 'urgent')"); err == nil {
+		t.Error("Tag names are not unique")
+	}
+}
+
+func TestMigrateRepairsStatusesWithoutHistory(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "statuses.db"))
+	if err != nil {
+		t.Fatal("Cannot open database", err)
+	}
+	defer db.Close()
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal("Cannot load migrations", err)
+	}
+	if err := migrator.MigrateTo(ctx, 13); err != nil {
+		t.Fatal("Error migrating to the schema without statuses", err)
+	}
+	if _, err := db.Exec("INSERT INTO todos (id, title, status) VALUES (1, 'stray', 7)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal("Error applying migrations", err)
+	}
+
+	var status, changes int
+	if err := db.QueryRow("SELECT status FROM todos WHERE id=1").Scan(&status); err != nil {
+		t.Error("Error reading status", err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM status_history").Scan(&changes); err != nil {
+		t.Error("Error counting status changes", err)
+	}
+	if status != 0 || changes != 0 {
+		t.Error("Repairing the status was recorded as a change", status, changes)
+	}
+
+	// the trigger is back in place
+	if _, err := db.Exec("UPDATE todos SET status = 1 WHERE id=1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM status_history").Scan(&changes); err != nil {
+		t.Error("Error counting status changes", err)
+	}
+	if changes != 1 {
+		t.Error("Status changes are no longer recorded", changes)
+	}
+}
+
+func TestMigrateFoldsTagNamesLikeTheStore(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "tagkeys.db"))
+	if err != nil {
+		t.Fatal("Cannot open database", err)
+	}
+	defer db.Close()
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal("Cannot load migrations", err)
+	}
+	if err := migrator.MigrateTo(ctx, 4); err != nil {
+		t.Fatal("Error migrating to the schema without unique tag names", err)
+	}
+
+	for _, stmt := range []string{
+		"INSERT INTO todos (id, title, status) VALUES (1, 'first', 0), (2, 'second', 0)",
+		"INSERT INTO tags (id, name) VALUES (1, 'team/backend'), (2, ' Team / Backend '), (3, 'Équipe'), (4, 'équipe')",
+		"INSERT INTO todos_tags (todo_id, tag_id) VALUES (1, 2), (2, 4)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal("Error applying migrations", err)
+	}
+
+	rows, err := db.Query("SELECT t.name, COUNT(jt.todo_id) FROM tags t LEFT JOIN todos_tags jt ON jt.tag_id = t.id GROUP BY t.id ORDER BY t.id")
+	if err != nil {
+		t.Fatal("Error listing tags", err)
+	}
+	defer rows.Close()
+	var tags []string
+	for rows.Next() {
+		var name string
+		var todos int
+		if err := rows.Scan(&name, &todos); err != nil {
+			t.Fatal(err)
+		}
+		tags = append(tags, fmt.Sprintf("%s:%d", name, todos))
+	}
+	if got := strings.Join(tags, ","); got != "team/backend:1,Équipe:1" {
+		t.Error("Tags were not folded by their normalised name", got)
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS todos_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS todos;
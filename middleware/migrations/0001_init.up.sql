@@ -0,0 +1,5 @@
+-- Baseline schema. Uses IF NOT EXISTS so databases created before
+-- migrations were introduced are adopted as-is.
+CREATE TABLE IF NOT EXISTS todos (id INTEGER PRIMARY KEY, title TEXT, description TEXT, createdAt TIMESTAMP default (strftime('%s', 'now')), updatedAt TIMESTAMP DEFAULT (strftime('%s', 'now')), status INTEGER);
+CREATE TABLE IF NOT EXISTS tags (id INTEGER PRIMARY KEY, name STRING, createdAt TIMESTAMP default (strftime('%s', 'now')));
+CREATE TABLE IF NOT EXISTS todos_tags (id INTEGER PRIMARY KEY, todo_id INTEGER, tag_id INTEGER);
@@ -0,0 +1,20 @@
+-- requires: fts5
+-- Full-text index over todo titles and descriptions. It is an external
+-- content table reading from todos, kept in sync by the triggers below.
+CREATE VIRTUAL TABLE todos_fts USING fts5(title, description, content='todos', content_rowid='id', tokenize='unicode61 remove_diacritics 2');
+
+CREATE TRIGGER todos_fts_insert AFTER INSERT ON todos BEGIN
+    INSERT INTO todos_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+END;
+
+CREATE TRIGGER todos_fts_delete AFTER DELETE ON todos BEGIN
+    INSERT INTO todos_fts (todos_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+END;
+
+CREATE TRIGGER todos_fts_update AFTER UPDATE OF title, description ON todos BEGIN
+    INSERT INTO todos_fts (todos_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+    INSERT INTO todos_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+END;
+
+-- index the todos that already exist
+INSERT INTO todos_fts (todos_fts) VALUES ('rebuild');
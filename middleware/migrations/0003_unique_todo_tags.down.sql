@@ -0,0 +1 @@
+DROP INDEX IF EXISTS todos_tags_todo_tag;
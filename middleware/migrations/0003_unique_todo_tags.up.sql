@@ -0,0 +1,4 @@
+-- keep the oldest of any duplicated (todo, tag) association
+DELETE FROM todos_tags WHERE id NOT IN (SELECT MIN(id) FROM todos_tags GROUP BY todo_id, tag_id);
+
+CREATE UNIQUE INDEX todos_tags_todo_tag ON todos_tags (todo_id, tag_id);
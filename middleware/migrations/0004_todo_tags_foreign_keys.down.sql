@@ -0,0 +1,7 @@
+CREATE TABLE todos_tags_old (id INTEGER PRIMARY KEY, todo_id INTEGER, tag_id INTEGER);
+INSERT INTO todos_tags_old (id, todo_id, tag_id) SELECT id, todo_id, tag_id FROM todos_tags;
+
+DROP TABLE todos_tags;
+ALTER TABLE todos_tags_old RENAME TO todos_tags;
+
+CREATE UNIQUE INDEX todos_tags_todo_tag ON todos_tags (todo_id, tag_id);
@@ -0,0 +1,18 @@
+-- SQLite cannot add constraints to an existing table, so todos_tags is
+-- rebuilt with foreign keys. Associations whose todo or tag is gone are
+-- dropped on the way.
+CREATE TABLE todos_tags_new (
+	id INTEGER PRIMARY KEY,
+	todo_id INTEGER NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+	tag_id INTEGER NOT NULL REFERENCES tags (id) ON DELETE CASCADE
+);
+
+INSERT INTO todos_tags_new (id, todo_id, tag_id)
+SELECT id, todo_id, tag_id FROM todos_tags
+WHERE todo_id IN (SELECT id FROM todos) AND tag_id IN (SELECT id FROM tags);
+
+DROP TABLE todos_tags;
+ALTER TABLE todos_tags_new RENAME TO todos_tags;
+
+CREATE UNIQUE INDEX todos_tags_todo_tag ON todos_tags (todo_id, tag_id);
+CREATE INDEX todos_tags_tag ON todos_tags (tag_id);
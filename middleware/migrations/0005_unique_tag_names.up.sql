@@ -0,0 +1,13 @@
+-- Tag names are unique ignoring case and surrounding whitespace. Duplicates
+-- are folded into the oldest tag of the same name, keeping its associations.
+-- tag_name is the store's normalizeTagName, see registerFunctions.
+UPDATE tags SET name = tag_name(name);
+
+UPDATE OR IGNORE todos_tags SET tag_id = (
+	SELECT MIN(keep.id) FROM tags keep JOIN tags t ON keep.name = t.name COLLATE NOCASE
+	WHERE t.id = todos_tags.tag_id
+);
+DELETE FROM tags WHERE id NOT IN (SELECT MIN(id) FROM tags GROUP BY name COLLATE NOCASE);
+DELETE FROM todos_tags WHERE tag_id NOT IN (SELECT id FROM tags);
+
+CREATE UNIQUE INDEX tags_name ON tags (name COLLATE NOCASE);
@@ -0,0 +1,3 @@
+ALTER TABLE tags DROP COLUMN updatedAt;
+ALTER TABLE tags DROP COLUMN description;
+ALTER TABLE tags DROP COLUMN color;
@@ -0,0 +1,5 @@
+ALTER TABLE tags ADD COLUMN color TEXT NOT NULL DEFAULT '';
+ALTER TABLE tags ADD COLUMN description TEXT NOT NULL DEFAULT '';
+-- ADD COLUMN only takes constant defaults, so inserts set updatedAt themselves
+ALTER TABLE tags ADD COLUMN updatedAt TIMESTAMP;
+UPDATE tags SET updatedAt = createdAt;
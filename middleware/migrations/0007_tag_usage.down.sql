@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS todos_tags_tagged_at;
+ALTER TABLE todos_tags DROP COLUMN taggedAt;
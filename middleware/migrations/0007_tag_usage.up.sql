@@ -0,0 +1,9 @@
+-- taggedAt records when a tag was put on a todo, for ranking tag suggestions.
+-- Existing associations take the last update of their todo.
+ALTER TABLE todos_tags ADD COLUMN taggedAt TIMESTAMP;
+UPDATE todos_tags SET taggedAt = (SELECT updatedAt FROM todos WHERE todos.id = todos_tags.todo_id);
+
+-- ADD COLUMN only takes constant defaults, so new rows are stamped here
+CREATE TRIGGER todos_tags_tagged_at AFTER INSERT ON todos_tags WHEN NEW.taggedAt IS NULL BEGIN
+	UPDATE todos_tags SET taggedAt = strftime('%s', 'now') WHERE id = NEW.id;
+END;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS todos_due;
+ALTER TABLE todos DROP COLUMN dueTime;
+ALTER TABLE todos DROP COLUMN dueAt;
@@ -0,0 +1,5 @@
+-- dueAt keeps the due date as the client sent it, a date or a time with its
+-- offset; dueTime is the instant of a time in unix seconds, NULL for dates
+ALTER TABLE todos ADD COLUMN dueAt TEXT;
+ALTER TABLE todos ADD COLUMN dueTime INTEGER;
+CREATE INDEX todos_due ON todos (dueTime, dueAt);
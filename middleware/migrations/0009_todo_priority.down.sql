@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS todos_importance;
+ALTER TABLE todos DROP COLUMN priority;
@@ -0,0 +1,4 @@
+ALTER TABLE todos ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
+
+-- serves the default list order, see todoImportance
+CREATE INDEX todos_importance ON todos ((CASE WHEN status = 2 THEN 5 ELSE 0 END + 4 - priority), id);
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS todos_orphan_children;
+DROP INDEX IF EXISTS todos_parent;
+ALTER TABLE todos DROP COLUMN autoClose;
+ALTER TABLE todos DROP COLUMN parentId;
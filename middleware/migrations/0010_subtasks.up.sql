@@ -0,0 +1,10 @@
+-- parentId makes a todo a subtask of another. It has no foreign key because
+-- SQLite cannot drop such a column again; the store checks parents exist and
+-- the trigger promotes the children of a deleted todo to the top level.
+ALTER TABLE todos ADD COLUMN parentId INTEGER;
+ALTER TABLE todos ADD COLUMN autoClose INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX todos_parent ON todos (parentId);
+
+CREATE TRIGGER todos_orphan_children AFTER DELETE ON todos BEGIN
+	UPDATE todos SET parentId = NULL WHERE parentId = OLD.id;
+END;
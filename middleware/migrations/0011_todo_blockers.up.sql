@@ -0,0 +1,11 @@
+-- todo_id is blocked by blocker_id until the blocker is Closed
+CREATE TABLE todos_blockers (
+	id INTEGER PRIMARY KEY,
+	todo_id INTEGER NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+	blocker_id INTEGER NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+	createdAt TIMESTAMP DEFAULT (strftime('%s', 'now')),
+	CHECK (todo_id != blocker_id)
+);
+
+CREATE UNIQUE INDEX todos_blockers_todo_blocker ON todos_blockers (todo_id, blocker_id);
+CREATE INDEX todos_blockers_blocker ON todos_blockers (blocker_id);
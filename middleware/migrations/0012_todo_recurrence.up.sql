@@ -0,0 +1,2 @@
+-- recurrence holds an RRULE, see recurrence.go; NULL for one-off todos
+ALTER TABLE todos ADD COLUMN recurrence TEXT;
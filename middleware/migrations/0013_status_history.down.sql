@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS todos_status_history;
+DROP TABLE IF EXISTS status_history;
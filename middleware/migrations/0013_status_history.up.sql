@@ -0,0 +1,15 @@
+-- every status change of a todo, written by the trigger whichever way the
+-- status is updated
+CREATE TABLE status_history (
+	id INTEGER PRIMARY KEY,
+	todo_id INTEGER NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+	fromStatus INTEGER NOT NULL,
+	toStatus INTEGER NOT NULL,
+	changedAt TIMESTAMP DEFAULT (strftime('%s', 'now'))
+);
+
+CREATE INDEX status_history_todo ON status_history (todo_id);
+
+CREATE TRIGGER todos_status_history AFTER UPDATE OF status ON todos WHEN OLD.status != NEW.status BEGIN
+	INSERT INTO status_history (todo_id, fromStatus, toStatus) VALUES (NEW.id, OLD.status, NEW.status);
+END;
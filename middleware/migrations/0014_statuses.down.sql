@@ -0,0 +1,14 @@
+-- custom statuses fall back to the seeded status of their category, out of
+-- the reach of the history trigger
+DROP TRIGGER todos_status_history;
+UPDATE todos SET status = (
+	SELECT CASE category WHEN 'done' THEN 2 WHEN 'active' THEN 1 ELSE 0 END FROM statuses WHERE statuses.id = todos.status
+) WHERE status NOT IN (0, 1, 2);
+CREATE TRIGGER todos_status_history AFTER UPDATE OF status ON todos WHEN OLD.status != NEW.status BEGIN
+	INSERT INTO status_history (todo_id, fromStatus, toStatus) VALUES (NEW.id, OLD.status, NEW.status);
+END;
+
+CREATE INDEX todos_importance ON todos ((CASE WHEN status = 2 THEN 5 ELSE 0 END + 4 - priority), id);
+
+DROP INDEX IF EXISTS statuses_name;
+DROP TABLE IF EXISTS statuses;
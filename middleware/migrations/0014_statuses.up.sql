@@ -0,0 +1,32 @@
+-- Statuses become rows. The seeded ones keep the ids of the former Open,
+-- In Progress and Closed constants, so todos.status holds a status id as
+-- it is. Like parentId it has no foreign key; the store checks that a status
+-- exists and refuses to delete one in use. Ids are never reused, as the
+-- status history and the configured workflow keep them.
+CREATE TABLE statuses (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	category TEXT NOT NULL CHECK (category IN ('open', 'active', 'done')),
+	createdAt TIMESTAMP DEFAULT (strftime('%s', 'now')),
+	updatedAt TIMESTAMP DEFAULT (strftime('%s', 'now'))
+);
+
+-- names are unique the way the store matches them, see statusKey
+CREATE UNIQUE INDEX statuses_name ON statuses (replace(replace(replace(lower(name), ' ', ''), '_', ''), '-', ''));
+
+INSERT INTO statuses (id, name, position, category) VALUES
+	(0, 'Open', 1, 'open'),
+	(1, 'In Progress', 2, 'active'),
+	(2, 'Closed', 3, 'done');
+
+-- statuses outside the enum used to be accepted; repairing them is not a
+-- status change, so the history trigger is set aside meanwhile
+DROP TRIGGER todos_status_history;
+UPDATE todos SET status = 0 WHERE status IS NULL OR status NOT IN (0, 1, 2);
+CREATE TRIGGER todos_status_history AFTER UPDATE OF status ON todos WHEN OLD.status != NEW.status BEGIN
+	INSERT INTO status_history (todo_id, fromStatus, toStatus) VALUES (NEW.id, OLD.status, NEW.status);
+END;
+
+-- importance now ranks by status category, which an index cannot look up
+DROP INDEX IF EXISTS todos_importance;
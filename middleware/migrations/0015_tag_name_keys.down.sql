@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS tags_name_key;
+ALTER TABLE tags DROP COLUMN nameKey;
+
+CREATE UNIQUE INDEX tags_name ON tags (name COLLATE NOCASE);
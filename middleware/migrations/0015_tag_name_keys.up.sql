@@ -0,0 +1,17 @@
+-- Tag names are unique by tagKey, which folds case beyond ASCII, kept in
+-- nameKey. tag_name and tag_key are the store's normalizeTagName and tagKey,
+-- see registerFunctions. Names are normalised again first, and tags that
+-- now share a key are folded into the oldest, keeping its associations.
+DROP INDEX IF EXISTS tags_name;
+
+ALTER TABLE tags ADD COLUMN nameKey TEXT NOT NULL DEFAULT '';
+UPDATE tags SET name = tag_name(name), nameKey = tag_key(name);
+
+UPDATE OR IGNORE todos_tags SET tag_id = (
+	SELECT MIN(keep.id) FROM tags keep JOIN tags t ON keep.nameKey = t.nameKey
+	WHERE t.id = todos_tags.tag_id
+);
+DELETE FROM tags WHERE id NOT IN (SELECT MIN(id) FROM tags GROUP BY nameKey);
+DELETE FROM todos_tags WHERE tag_id NOT IN (SELECT id FROM tags);
+
+CREATE UNIQUE INDEX tags_name_key ON tags (nameKey);
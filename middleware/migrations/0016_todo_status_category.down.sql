@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS todos_importance;
+DROP TRIGGER IF EXISTS statuses_category_update;
+DROP TRIGGER IF EXISTS todos_status_category_update;
+DROP TRIGGER IF EXISTS todos_status_category_insert;
+ALTER TABLE todos DROP COLUMN statusCategory;
@@ -0,0 +1,19 @@
+-- statusCategory copies the category of the status of each todo, so the
+-- default list order can be served by an index again, see todoImportance.
+-- The triggers keep it in step with todos.status and statuses.category.
+ALTER TABLE todos ADD COLUMN statusCategory TEXT NOT NULL DEFAULT 'open';
+UPDATE todos SET statusCategory = COALESCE((SELECT category FROM statuses WHERE statuses.id = todos.status), 'open');
+
+CREATE TRIGGER todos_status_category_insert AFTER INSERT ON todos BEGIN
+	UPDATE todos SET statusCategory = COALESCE((SELECT category FROM statuses WHERE statuses.id = NEW.status), 'open') WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER todos_status_category_update AFTER UPDATE OF status ON todos BEGIN
+	UPDATE todos SET statusCategory = COALESCE((SELECT category FROM statuses WHERE statuses.id = NEW.status), 'open') WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER statuses_category_update AFTER UPDATE OF category ON statuses BEGIN
+	UPDATE todos SET statusCategory = NEW.category WHERE status = NEW.id;
+END;
+
+CREATE INDEX todos_importance ON todos ((CASE WHEN statusCategory = 'done' THEN 5 ELSE 0 END + 4 - priority), id);
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Page size limits for the list endpoints
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+// Page selects one window of a list. Pages are keyed on the last row seen
+// rather than an offset, so they stay stable while rows are inserted and
+// deleted concurrently.
+type Page struct {
+	// Limit is the maximum number of rows to return, DefaultPageLimit when 0
+	Limit int
+	// Cursor is the opaque position returned with the previous page, empty for the first page
+	Cursor string
+}
+
+func (p Page) limit() int {
+	if p.Limit <= 0 {
+		return DefaultPageLimit
+	}
+	if p.Limit > MaxPageLimit {
+		return MaxPageLimit
+	}
+	return p.Limit
+}
+
+// cursor is the decoded form of Page.Cursor: the sort order of the list and
+// the sort key and id of the last row of the previous page
+type cursor struct {
+	ID   int64       `json:"id"`
+	Sort string      `json:"sort,omitempty"`
+	Key  interface{} `json:"key,omitempty"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	if s == "" {
+		return c, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&c)
+	}
+	if err != nil {
+		return c, errBadRequest{fmt.Errorf("malformed cursor %q", s)}
+	}
+
+	// keys are compared against sqlite integers or text
+	if n, ok := c.Key.(json.Number); ok {
+		if c.Key, err = n.Int64(); err != nil {
+			return c, errBadRequest{fmt.Errorf("malformed cursor %q", s)}
+		}
+	}
+	return c, nil
+}
+
+// pageFromRequest reads the limit and cursor query parameters
+func pageFromRequest(r *http.Request) (Page, error) {
+	query := r.URL.Query()
+	page := Page{Cursor: query.Get("cursor")}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxPageLimit {
+			return page, errBadRequest{fmt.Errorf("limit must be an integer between 1 and %d, got %q", MaxPageLimit, v)}
+		}
+		page.Limit = limit
+	}
+
+	if _, err := decodeCursor(page.Cursor); err != nil {
+		return page, err
+	}
+	return page, nil
+}
+
+// setNextLink advertises the next page, if there is one, in a Link header
+func setNextLink(w http.ResponseWriter, r *http.Request, next string) {
+	if next == "" {
+		return
+	}
+
+	query := r.URL.Query()
+	query.Set("cursor", next)
+	u := *r.URL
+	u.RawQuery = query.Encode()
+
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+	w.Header().Set("X-Next-Cursor", next)
+}
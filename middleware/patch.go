@@ -0,0 +1,402 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"go-todo/models"
+)
+
+// Media types accepted by PATCH
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// TodoPatch lists the todo fields written by a partial update, nil fields
+// are left untouched
+type TodoPatch struct {
+	Title       *string
+	Description *string
+	Status      *models.ToDoStatus
+	Priority    *models.Priority
+	// DueAt is empty to remove the due date
+	DueAt *string
+	// ParentID is 0 to make the todo a top level one
+	ParentID  *int64
+	AutoClose *bool
+	// Recurrence is empty to stop the todo from recurring
+	Recurrence *string
+
+	// Force starts or closes the todo even while it waits on open blockers
+	Force bool
+	// Precondition, when set, is checked against the todo read by the
+	// transaction that writes it, such as the test operations of a JSON Patch
+	Precondition func(current models.ToDo) error
+}
+
+// IsEmpty reports whether the patch writes nothing
+func (p TodoPatch) IsEmpty() bool {
+	p.Force, p.Precondition = false, nil
+	return reflect.DeepEqual(p, TodoPatch{})
+}
+
+// todoPatchFields maps the JSON members a client may patch onto the setter
+// that validates the new value and records it. A JSON null removes the value.
+var todoPatchFields = map[string]func(p *TodoPatch, raw json.RawMessage) error{
+	"title": func(p *TodoPatch, raw json.RawMessage) error {
+		var v *string
+		if err := json.Unmarshal(raw, &v); err != nil || v == nil || strings.TrimSpace(*v) == "" {
+			return invalidf("title must be a non-empty string")
+		}
+		p.Title = v
+		return nil
+	},
+	"description": func(p *TodoPatch, raw json.RawMessage) error {
+		var v *string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return invalidf("description must be a string")
+		}
+		if v == nil {
+			v = new(string)
+		}
+		p.Description = v
+		return nil
+	},
+	"status": func(p *TodoPatch, raw json.RawMessage) error {
+		var v *models.ToDoStatus
+		if err := json.Unmarshal(raw, &v); err != nil || v == nil {
+			return invalidf("status must be a status id, see /api/status")
+		}
+		p.Status = v
+		return nil
+	},
+	"priority": func(p *TodoPatch, raw json.RawMessage) error {
+		var v *models.Priority
+		if err := json.Unmarshal(raw, &v); err != nil || v == nil || !v.Valid() {
+			return invalidf("priority must be one of 0 (None), 1 (Low), 2 (Medium), 3 (High) or 4 (Urgent)")
+		}
+		p.Priority = v
+		return nil
+	},
+	"parentId": func(p *TodoPatch, raw json.RawMessage) error {
+		var v *int64
+		if err := json.Unmarshal(raw, &v); err != nil || (v != nil && *v < 0) {
+			return invalidf("parentId must be a todo id")
+		}
+		if v == nil {
+			v = new(int64)
+		}
+		p.ParentID = v
+		return nil
+	},
+	"autoClose": func(p *TodoPatch, raw json.RawMessage) error {
+		var v *bool
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return invalidf("autoClose must be a boolean")
+		}
+		if v == nil {
+			v = new(bool)
+		}
+		p.AutoClose = v
+		return nil
+	},
+	"dueAt": func(p *TodoPatch, raw json.RawMessage) error {
+		var v *string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return invalidf("dueAt must be a string")
+		}
+		if v == nil {
+			v = new(string)
+		}
+		if *v != "" {
+			if _, _, err := parseDue(*v); err != nil {
+				return err
+			}
+		}
+		p.DueAt = v
+		return nil
+	},
+	"recurrence": func(p *TodoPatch, raw json.RawMessage) error {
+		var v *string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return invalidf("recurrence must be a string")
+		}
+		if v == nil {
+			v = new(string)
+		}
+		if *v != "" {
+			if _, err := parseRecurrence(*v); err != nil {
+				return err
+			}
+		}
+		p.Recurrence = v
+		return nil
+	},
+}
+
+// todoReadOnlyFields are members of a todo that exist but cannot be patched
+var todoReadOnlyFields = map[string]bool{"id": true, "createdAt": true, "updatedAt": true, "tags": true, "progress": true}
+
+func (p *TodoPatch) set(name string, raw json.RawMessage) error {
+	set, ok := todoPatchFields[name]
+	if !ok {
+		if todoReadOnlyFields[name] {
+			return invalidf("%s cannot be changed", name)
+		}
+		return invalidf("todos have no field %q", name)
+	}
+	return set(p, raw)
+}
+
+// TagPatch lists the tag fields written by a partial update, nil fields are
+// left untouched
+type TagPatch struct {
+	Name        *string
+	Color       *string
+	Description *string
+
+	// Precondition, when set, is checked against the tag read by the
+	// transaction that writes it, see TodoPatch
+	Precondition func(current models.Tag) error
+}
+
+// IsEmpty reports whether the patch writes nothing
+func (p TagPatch) IsEmpty() bool {
+	p.Precondition = nil
+	return reflect.DeepEqual(p, TagPatch{})
+}
+
+// tagPatchFields maps the JSON members of a tag onto their setters, see
+// todoPatchFields
+var tagPatchFields = map[string]func(p *TagPatch, raw json.RawMessage) error{
+	"name": func(p *TagPatch, raw json.RawMessage) error {
+		var v *string
+		if err := json.Unmarshal(raw, &v); err != nil || v == nil || normalizeTagName(*v) == "" {
+			return invalidf("name must be a non-empty string")
+		}
+		p.Name = v
+		return nil
+	},
+	"color": func(p *TagPatch, raw json.RawMessage) error {
+		var v *string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return invalidf("color must be a string")
+		}
+		if v == nil {
+			v = new(string)
+		}
+		if err := validateColor(*v); err != nil {
+			return err
+		}
+		p.Color = v
+		return nil
+	},
+	"description": func(p *TagPatch, raw json.RawMessage) error {
+		var v *string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return invalidf("description must be a string")
+		}
+		if v == nil {
+			v = new(string)
+		}
+		p.Description = v
+		return nil
+	},
+}
+
+// tagReadOnlyFields are members of a tag that exist but cannot be patched
+var tagReadOnlyFields = map[string]bool{"id": true, "createdAt": true, "updatedAt": true}
+
+func (p *TagPatch) set(name string, raw json.RawMessage) error {
+	set, ok := tagPatchFields[name]
+	if !ok {
+		if tagReadOnlyFields[name] {
+			return invalidf("%s cannot be changed", name)
+		}
+		return invalidf("tags have no field %q", name)
+	}
+	return set(p, raw)
+}
+
+// fieldSetter validates and records the new value of one member of a
+// patched document
+type fieldSetter func(name string, raw json.RawMessage) error
+
+// mergePatch reads an RFC 7396 JSON Merge Patch. Every member present in the
+// document is written, members left out are kept.
+func mergePatch(body []byte, set fieldSetter) error {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(body, &doc); err != nil || doc == nil {
+		return errBadRequest{fmt.Errorf("a merge patch must be a JSON object")}
+	}
+	for name, raw := range doc {
+		if err := set(name, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonPatchOp is one operation of an RFC 6902 JSON Patch
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonPatch applies an RFC 6902 JSON Patch to current. Paths address the
+// top level members of the document, such as /title. The fields touched by
+// any operation are written; a failing test operation is a conflict. The
+// returned check applies the patch again to a fresh read of the document and
+// fails unless it passes and writes the same values, so the store can repeat
+// it under its write lock.
+func jsonPatch(body []byte, current interface{}, set fieldSetter) (func(current interface{}) error, error) {
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, errBadRequest{fmt.Errorf("a JSON patch must be an array of operations")}
+	}
+
+	written, err := applyJSONPatch(ops, current)
+	if err != nil {
+		return nil, err
+	}
+	for name, raw := range written {
+		if err := set(name, raw); err != nil {
+			return nil, err
+		}
+	}
+
+	check := func(current interface{}) error {
+		again, err := applyJSONPatch(ops, current)
+		if err != nil {
+			return err
+		}
+		for name, raw := range written {
+			if !jsonEqual(again[name], raw) {
+				return conflictf("%s changed while the patch was applied", name)
+			}
+		}
+		return nil
+	}
+	return check, nil
+}
+
+// applyJSONPatch runs ops against current and returns the new value of every
+// member they touch
+func applyJSONPatch(ops []jsonPatchOp, current interface{}) (map[string]json.RawMessage, error) {
+	encoded, _ := json.Marshal(current)
+	var doc map[string]json.RawMessage
+	json.Unmarshal(encoded, &doc)
+
+	member := func(pointer string) (string, error) {
+		name := strings.TrimPrefix(pointer, "/")
+		if !strings.HasPrefix(pointer, "/") || strings.Contains(name, "/") {
+			return "", invalidf("unsupported path %q, only top level members such as /description can be patched", pointer)
+		}
+		return strings.NewReplacer("~1", "/", "~0", "~").Replace(name), nil
+	}
+
+	touched := map[string]bool{}
+	for i, op := range ops {
+		name, err := member(op.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.Op {
+		case "add", "replace":
+			if op.Value == nil {
+				return nil, errBadRequest{fmt.Errorf("operation %d: %s needs a value", i, op.Op)}
+			}
+			doc[name] = op.Value
+			touched[name] = true
+		case "remove":
+			doc[name] = json.RawMessage("null")
+			touched[name] = true
+		case "copy", "move":
+			from, err := member(op.From)
+			if err != nil {
+				return nil, err
+			}
+			value, ok := doc[from]
+			if !ok {
+				return nil, invalidf("operation %d: %s does not exist", i, op.From)
+			}
+			doc[name] = value
+			touched[name] = true
+			if op.Op == "move" {
+				doc[from] = json.RawMessage("null")
+				touched[from] = true
+			}
+		case "test":
+			if !jsonEqual(doc[name], op.Value) {
+				return nil, conflictf("operation %d: test of %s failed", i, op.Path)
+			}
+		default:
+			return nil, errBadRequest{fmt.Errorf("operation %d: unknown op %q", i, op.Op)}
+		}
+	}
+
+	written := make(map[string]json.RawMessage, len(touched))
+	for name := range touched {
+		written[name] = doc[name]
+	}
+	return written, nil
+}
+
+// jsonEqual compares two JSON values ignoring formatting
+func jsonEqual(a, b json.RawMessage) bool {
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(bytes.TrimSpace(b), &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// decodePatch reads a PATCH body in the given format and records the fields
+// it writes through set. JSON Patch operations are evaluated against the
+// document returned by current, and the check they must pass again when the
+// document is written is returned; a merge patch has none.
+func decodePatch(r *http.Request, mediaType string, current func() (interface{}, error), set fieldSetter) (func(current interface{}) error, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, errBadRequest{fmt.Errorf("unable to read the request body: %w", err)}
+	}
+
+	if mediaType == jsonPatchType {
+		doc, err := current()
+		if err != nil {
+			return nil, err
+		}
+		return jsonPatch(body, doc, set)
+	}
+	return nil, mergePatch(body, set)
+}
+
+// patchMediaType returns the patch format named by the request Content-Type.
+// Plain JSON is read as a merge patch.
+func patchMediaType(r *http.Request) (string, bool) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return mergePatchType, true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+	switch mediaType {
+	case mergePatchType, "application/json":
+		return mergePatchType, true
+	case jsonPatchType:
+		return jsonPatchType, true
+	}
+	return "", false
+}
@@ -0,0 +1,435 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A recurring todo carries an RFC 5545 RRULE and a due date, which serves as
+// the start of the series. Moving it to a done status, such as Closed,
+// creates the next occurrence: a copy of the todo, with its tags, in the
+// first open status and due at the next date of the rule. The rule moves to
+// the copy, so reopening and finishing the todo again does not fork the
+// series. COUNT counts the todo itself, so the copy carries the rule with
+// COUNT lowered by one and the series ends once a todo with COUNT=1 is done.
+//
+// The supported subset is FREQ (DAILY, WEEKLY, MONTHLY or YEARLY), INTERVAL,
+// BYDAY, COUNT and UNTIL. Weeks start on Monday. Occurrences keep the time
+// of day and the UTC offset of the due date.
+
+// DefaultOccurrences and MaxOccurrences bound the occurrence preview
+const (
+	DefaultOccurrences = 5
+	MaxOccurrences     = 100
+)
+
+// maxRecurrencePeriods stops the search for occurrences of a rule that
+// rarely, or never, produces one
+const maxRecurrencePeriods = 10000
+
+var rruleFreqs = map[string]bool{"DAILY": true, "WEEKLY": true, "MONTHLY": true, "YEARLY": true}
+
+var rruleDays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// weekdayNum is a BYDAY entry: a weekday, and for MONTHLY and YEARLY rules
+// optionally its nth occurrence in the month or year, counted from the end
+// when negative. N is 0 for every such weekday.
+type weekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+func (w weekdayNum) String() string {
+	for name, day := range rruleDays {
+		if day == w.Day {
+			if w.N == 0 {
+				return name
+			}
+			return strconv.Itoa(w.N) + name
+		}
+	}
+	return ""
+}
+
+// recurrence is a parsed RRULE
+type recurrence struct {
+	Freq     string
+	Interval int
+	ByDay    []weekdayNum
+	// Count is the number of occurrences left, 0 for no limit
+	Count int
+	// Until is the last moment an occurrence may fall on; UntilDate marks a
+	// date only UNTIL, which lasts to the end of its day
+	Until     time.Time
+	UntilDate bool
+}
+
+// parseRecurrence validates an RRULE, with or without its "RRULE:" prefix
+func parseRecurrence(v string) (recurrence, error) {
+	rule := recurrence{Interval: 1}
+	body := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(v)), "RRULE:")
+	seen := map[string]bool{}
+
+	for _, part := range strings.Split(body, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return rule, invalidf("recurrence must be NAME=value pairs separated by ';', got %q", part)
+		}
+		if seen[name] {
+			return rule, invalidf("recurrence repeats %s", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "FREQ":
+			if !rruleFreqs[value] {
+				return rule, invalidf("FREQ must be DAILY, WEEKLY, MONTHLY or YEARLY, got %q", value)
+			}
+			rule.Freq = value
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return rule, invalidf("INTERVAL must be a positive integer, got %q", value)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return rule, invalidf("COUNT must be a positive integer, got %q", value)
+			}
+			rule.Count = n
+		case "UNTIL":
+			if t, err := time.Parse("20060102T150405Z", value); err == nil {
+				rule.Until = t
+			} else if t, err := time.Parse("20060102", value); err == nil {
+				rule.Until, rule.UntilDate = t, true
+			} else {
+				return rule, invalidf("UNTIL must be YYYYMMDD or YYYYMMDDTHHMMSSZ, got %q", value)
+			}
+		case "BYDAY":
+			for _, entry := range strings.Split(value, ",") {
+				w, err := parseWeekdayNum(entry)
+				if err != nil {
+					return rule, err
+				}
+				rule.ByDay = append(rule.ByDay, w)
+			}
+		default:
+			return rule, invalidf("recurrence supports FREQ, INTERVAL, BYDAY, COUNT and UNTIL, got %s", name)
+		}
+	}
+
+	if rule.Freq == "" {
+		return rule, invalidf("recurrence needs a FREQ")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return rule, invalidf("recurrence cannot have both COUNT and UNTIL")
+	}
+	// ordinals count within a month or a year, other rules take plain days
+	limit := map[string]int{"MONTHLY": 5, "YEARLY": 53}[rule.Freq]
+	for _, w := range rule.ByDay {
+		if w.N > limit || w.N < -limit {
+			return rule, invalidf("BYDAY %s is out of range for a %s rule", w, rule.Freq)
+		}
+	}
+	return rule, nil
+}
+
+// parseWeekdayNum parses a BYDAY entry such as MO, 1MO or -1FR
+func parseWeekdayNum(v string) (weekdayNum, error) {
+	if len(v) < 2 {
+		return weekdayNum{}, invalidf("BYDAY entries must be weekdays such as MO or -1FR, got %q", v)
+	}
+	day, ok := rruleDays[v[len(v)-2:]]
+	if !ok {
+		return weekdayNum{}, invalidf("BYDAY entries must be weekdays such as MO or -1FR, got %q", v)
+	}
+	w := weekdayNum{Day: day}
+	if prefix := v[:len(v)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 {
+			return weekdayNum{}, invalidf("BYDAY entries must be weekdays such as MO or -1FR, got %q", v)
+		}
+		w.N = n
+	}
+	return w, nil
+}
+
+// String returns the rule in canonical form
+func (r recurrence) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, w := range r.ByDay {
+			days[i] = w.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.UntilDate {
+		parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+	} else if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// after returns up to n occurrences of the series starting at start,
+// excluding start itself
+func (r recurrence) after(start time.Time, n int) []time.Time {
+	if r.Count > 0 && n > r.Count-1 {
+		n = r.Count - 1
+	}
+	end := r.Until
+	if r.UntilDate {
+		// a date lasts until the end of its day where the series runs
+		end = time.Date(end.Year(), end.Month(), end.Day()+1, 0, 0, 0, 0, start.Location()).Add(-time.Nanosecond)
+	}
+
+	var found []time.Time
+	for k := 0; k < maxRecurrencePeriods && len(found) < n; k++ {
+		for _, t := range r.period(start, k) {
+			if !t.After(start) {
+				continue
+			}
+			if !end.IsZero() && t.After(end) {
+				return found
+			}
+			found = append(found, t)
+			if len(found) == n {
+				break
+			}
+		}
+	}
+	return found
+}
+
+// period returns the candidate occurrences of the kth period of the series,
+// in order
+func (r recurrence) period(start time.Time, k int) []time.Time {
+	y, m, d := start.Date()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	}
+	step := k * r.Interval
+
+	switch r.Freq {
+	case "DAILY":
+		t := at(y, m, d+step)
+		if len(r.ByDay) > 0 && !r.onDay(t.Weekday()) {
+			return nil
+		}
+		return []time.Time{t}
+	case "WEEKLY":
+		monday := d - (int(start.Weekday())+6)%7 + 7*step
+		if len(r.ByDay) == 0 {
+			return []time.Time{at(y, m, d+7*step)}
+		}
+		var days []time.Time
+		for i := 0; i < 7; i++ {
+			if t := at(y, m, monday+i); r.onDay(t.Weekday()) {
+				days = append(days, t)
+			}
+		}
+		return days
+	case "MONTHLY":
+		first := at(y, m+time.Month(step), 1)
+		if len(r.ByDay) == 0 {
+			return validDay(at(first.Year(), first.Month(), d), first.Month())
+		}
+		return r.expandByDay(first, first.AddDate(0, 1, 0))
+	case "YEARLY":
+		if len(r.ByDay) == 0 {
+			return validDay(at(y+step, m, d), m)
+		}
+		first := at(y+step, time.January, 1)
+		return r.expandByDay(first, first.AddDate(1, 0, 0))
+	}
+	return nil
+}
+
+// validDay drops t when its day overflowed into the month after month, as
+// the 31st does in a month of 30 days
+func validDay(t time.Time, month time.Month) []time.Time {
+	if t.Month() != month {
+		return nil
+	}
+	return []time.Time{t}
+}
+
+// onDay reports whether BYDAY lists the weekday
+func (r recurrence) onDay(day time.Weekday) bool {
+	for _, w := range r.ByDay {
+		if w.Day == day {
+			return true
+		}
+	}
+	return false
+}
+
+// expandByDay returns the days from first up to, excluding, end that match
+// BYDAY, in order
+func (r recurrence) expandByDay(first, end time.Time) []time.Time {
+	byDay := map[time.Weekday][]time.Time{}
+	for t := first; t.Before(end); t = t.AddDate(0, 0, 1) {
+		byDay[t.Weekday()] = append(byDay[t.Weekday()], t)
+	}
+
+	var days []time.Time
+	seen := map[time.Time]bool{}
+	for _, w := range r.ByDay {
+		matches := byDay[w.Day]
+		switch {
+		case w.N > 0 && w.N <= len(matches):
+			matches = matches[w.N-1 : w.N]
+		case w.N < 0 && -w.N <= len(matches):
+			matches = matches[len(matches)+w.N : len(matches)+w.N+1]
+		case w.N != 0:
+			matches = nil
+		}
+		for _, t := range matches {
+			if !seen[t] {
+				seen[t] = true
+				days = append(days, t)
+			}
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
+}
+
+// dueStart returns the instant a due date stands for: midnight UTC for a
+// date, and whether it was a date
+func dueStart(dueAt string) (time.Time, bool, error) {
+	if d, err := time.Parse(dateLayout, dueAt); err == nil {
+		return d, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, dueAt)
+	return t, false, err
+}
+
+// nextDueDates returns up to n due dates that follow dueAt under rule, in the
+// format of dueAt
+func nextDueDates(rule recurrence, dueAt string, n int) ([]string, error) {
+	start, isDate, err := dueStart(dueAt)
+	if err != nil {
+		return nil, invalidf("a recurring todo needs a dueAt")
+	}
+
+	dates := []string{}
+	for _, t := range rule.after(start, n) {
+		if isDate {
+			dates = append(dates, t.Format(dateLayout))
+		} else {
+			dates = append(dates, t.Format(time.RFC3339))
+		}
+	}
+	return dates, nil
+}
+
+// recurrenceColumn returns the canonical rule to store, NULL when the todo
+// does not recur
+func recurrenceColumn(v string) (sql.NullString, error) {
+	if v == "" {
+		return sql.NullString{}, nil
+	}
+	rule, err := parseRecurrence(v)
+	return sql.NullString{String: rule.String(), Valid: err == nil}, err
+}
+
+// checkRecurrence makes sure a recurring todo has a due date to start from
+func checkRecurrence(ctx context.Context, q querier, id int64) error {
+	var dueless bool
+	err := q.QueryRowContext(ctx, "SELECT recurrence IS NOT NULL AND dueAt IS NULL FROM todos WHERE id=?", id).Scan(&dueless)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if dueless {
+		return invalidf("a recurring todo needs a dueAt")
+	}
+	return nil
+}
+
+// scheduleNext creates the next occurrence of the recurring todo id, with
+// its tags, and hands the rule over to it. It does nothing for a todo that
+// does not recur or whose series is over.
+func scheduleNext(ctx context.Context, tx *sql.Tx, id int64) error {
+	var rrule, dueAt sql.NullString
+	err := tx.QueryRowContext(ctx, "SELECT recurrence, dueAt FROM todos WHERE id=?", id).Scan(&rrule, &dueAt)
+	if err != nil || !rrule.Valid || !dueAt.Valid {
+		return err
+	}
+
+	rule, err := parseRecurrence(rrule.String)
+	if err != nil {
+		return err
+	}
+	next, err := nextDueDates(rule, dueAt.String, 1)
+	if err != nil || len(next) == 0 {
+		return err
+	}
+	if rule.Count > 0 {
+		rule.Count--
+	}
+	nextDue, nextTime, err := dueColumns(next[0])
+	if err != nil {
+		return err
+	}
+
+	response, err := tx.ExecContext(ctx, `INSERT INTO todos (title, description, status, priority, dueAt, dueTime, parentId, autoClose, recurrence)
+		SELECT title, description, `+firstOpenStatus+`, priority, ?, ?, parentId, autoClose, ? FROM todos WHERE id=?`,
+		nextDue, nextTime, rule.String(), id)
+	if err != nil {
+		return storeError(err)
+	}
+	nextID, err := response.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO todos_tags (tag_id, todo_id) SELECT tag_id, ? FROM todos_tags WHERE todo_id=?", nextID, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE todos SET recurrence=NULL WHERE id=?", id); err != nil {
+		return err
+	}
+
+	slog.Debug("scheduled the next occurrence", "table", "todos", "id", id, "next", nextID, "dueAt", next[0])
+	return nil
+}
+
+// Occurrences returns the due dates of the next n occurrences of a todo,
+// none when it does not recur
+func (s *SQLiteStore) Occurrences(ctx context.Context, id int64, n int) ([]string, error) {
+	var rrule, dueAt sql.NullString
+	err := s.db.QueryRowContext(ctx, "SELECT recurrence, dueAt FROM todos WHERE id=?", id).Scan(&rrule, &dueAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("todo %v: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !rrule.Valid {
+		return []string{}, nil
+	}
+
+	rule, err := parseRecurrence(rrule.String)
+	if err != nil {
+		return nil, err
+	}
+	return nextDueDates(rule, dueAt.String, n)
+}
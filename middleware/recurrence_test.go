@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRecurrenceOccurrences(t *testing.T) {
+	cases := []struct {
+		dueAt, rule string
+		n           int
+		want        string
+	}{
+		{"2026-10-19", "FREQ=DAILY;INTERVAL=2", 3, "2026-10-21,2026-10-23,2026-10-25"},
+		{"2026-10-19", "FREQ=WEEKLY;BYDAY=MO,TH", 3, "2026-10-22,2026-10-26,2026-10-29"},
+		{"2026-10-19", "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU", 2, "2026-10-20,2026-11-03"},
+		{"2026-01-31", "FREQ=MONTHLY", 3, "2026-03-31,2026-05-31,2026-07-31"},
+		{"2026-10-01", "FREQ=MONTHLY;BYDAY=-1FR", 2, "2026-10-30,2026-11-27"},
+		{"2024-02-29", "FREQ=YEARLY", 1, "2028-02-29"},
+		{"2026-10-19", "FREQ=YEARLY;BYDAY=1MO", 2, "2027-01-04,2028-01-03"},
+		{"2026-10-19", "FREQ=DAILY;COUNT=3", 5, "2026-10-20,2026-10-21"},
+		{"2026-10-19", "FREQ=WEEKLY;UNTIL=20261102", 5, "2026-10-26,2026-11-02"},
+		{"2026-10-19T09:30:00+02:00", "FREQ=DAILY;BYDAY=SA,SU", 2, "2026-10-24T09:30:00+02:00,2026-10-25T09:30:00+02:00"},
+	}
+	for _, c := range cases {
+		rule, err := parseRecurrence(c.rule)
+		if err != nil {
+			t.Error("Error parsing rule", c.rule, err)
+			continue
+		}
+		dates, err := nextDueDates(rule, c.dueAt, c.n)
+		if err != nil {
+			t.Error("Error expanding rule", c.rule, err)
+			continue
+		}
+		if got := strings.Join(dates, ","); got != c.want {
+			t.Error("Unexpected occurrences", c.rule, got)
+		}
+	}
+}
+
+func TestParseRecurrence(t *testing.T) {
+	rule, err := parseRecurrence("rrule:freq=monthly;byday=mo,-1fr;interval=1")
+	if err != nil {
+		t.Fatal("Error parsing rule", err)
+	}
+	if rule.String() != "FREQ=MONTHLY;BYDAY=MO,-1FR" {
+		t.Error("Unexpected canonical form", rule.String())
+	}
+
+	for _, v := range []string{
+		"FREQ=HOURLY",
+		"INTERVAL=2",
+		"FREQ=DAILY;COUNT=2;UNTIL=20270101",
+		"FREQ=DAILY;COUNT=0",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=DAILY;BYSETPOS=1",
+		"FREQ=DAILY;FREQ=WEEKLY",
+	} {
+		if _, err := parseRecurrence(v); !errors.Is(err, ErrInvalid) {
+			t.Error("Expected ErrInvalid", v, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"go-todo/models"
+)
+
+// Statuses are rows of the statuses table, and todos.status holds a status
+// id. Every database starts with Open, In Progress and Closed under the ids
+// of the models constants; the store relies on Closed to auto-close parents
+// and on Open to always have a status to start todos in, so they can be
+// renamed and reordered but not deleted or moved to another category.
+// Whether a todo is finished is decided by the category of its status,
+// never by its id.
+
+// doneStatuses selects the ids of the statuses of the done category
+const doneStatuses = "(SELECT id FROM statuses WHERE category = 'done')"
+
+// firstOpenStatus selects the status new todos start in, the first of the
+// open category in board order
+const firstOpenStatus = "(SELECT id FROM statuses WHERE category = 'open' ORDER BY position, id LIMIT 1)"
+
+// statusNameKey is the SQL form of statusKey, for matching names. The
+// statuses_name index makes it unique.
+const statusNameKey = "replace(replace(replace(lower(name), ' ', ''), '_', ''), '-', '')"
+
+var statusKeyReplacer = strings.NewReplacer(" ", "", "_", "", "-", "")
+
+// statusKey folds a status name the way clients may write it, so "In
+// Progress", "inProgress" and "in_progress" are the same status
+func statusKey(name string) string {
+	return statusKeyReplacer.Replace(strings.ToLower(name))
+}
+
+// statusColumns lists the status columns in the order of statusFields
+const statusColumns = "id, name, position, category, createdAt, updatedAt"
+
+// statusFields returns the scan destinations for statusColumns
+func statusFields(status *models.Status) []interface{} {
+	return []interface{}{&status.ID, &status.Name, &status.Order, &status.Category, &status.CreatedAt, &status.UpdatedAt}
+}
+
+// isSeededStatus reports whether id is one of the statuses every database
+// starts with
+func isSeededStatus(id int64) bool {
+	return id >= int64(models.Open) && id <= int64(models.Closed)
+}
+
+// GetStatus returns the status with the given id
+func (s *SQLiteStore) GetStatus(ctx context.Context, id int64) (models.Status, error) {
+	return getStatus(ctx, s.db, id)
+}
+
+func getStatus(ctx context.Context, q querier, id int64) (models.Status, error) {
+	var status models.Status
+	err := q.QueryRowContext(ctx, "SELECT "+statusColumns+" FROM statuses WHERE id=?", id).Scan(statusFields(&status)...)
+	switch err {
+	case sql.ErrNoRows:
+		return status, fmt.Errorf("status %v: %w", id, ErrNotFound)
+	case nil:
+		return status, nil
+	default:
+		return status, fmt.Errorf("getStatus: unable to scan the row: %w", err)
+	}
+}
+
+// statusByRef finds a status by its id or its name, see statusKey
+func statusByRef(ctx context.Context, q querier, ref string) (models.Status, error) {
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		return getStatus(ctx, q, id)
+	}
+
+	var status models.Status
+	err := q.QueryRowContext(ctx, "SELECT "+statusColumns+" FROM statuses WHERE "+statusNameKey+" = ?", statusKey(ref)).Scan(statusFields(&status)...)
+	if err == sql.ErrNoRows {
+		return status, fmt.Errorf("status %q: %w", ref, ErrNotFound)
+	}
+	return status, err
+}
+
+// GetAllStatuses returns every status in board order
+func (s *SQLiteStore) GetAllStatuses(ctx context.Context) ([]models.Status, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+statusColumns+" FROM statuses ORDER BY position, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := []models.Status{}
+	for rows.Next() {
+		var status models.Status
+		if err := rows.Scan(statusFields(&status)...); err != nil {
+			return nil, fmt.Errorf("getAllStatuses: unable to scan the row: %w", err)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, rows.Err()
+}
+
+// InsertStatus creates a status. An order of 0 places it after the others.
+func (s *SQLiteStore) InsertStatus(ctx context.Context, status models.Status) (models.Status, error) {
+	response, err := s.db.ExecContext(ctx, `INSERT INTO statuses (name, position, category)
+		SELECT ?, CASE WHEN ? != 0 THEN ? ELSE COALESCE(MAX(position), 0) + 1 END, ? FROM statuses`,
+		strings.TrimSpace(status.Name), status.Order, status.Order, status.Category)
+	if err != nil {
+		return models.Status{}, storeError(err)
+	}
+
+	id, err := response.LastInsertId()
+	if err != nil {
+		return models.Status{}, err
+	}
+	slog.Debug("inserted a single record", "table", "statuses", "id", id)
+
+	return s.GetStatus(ctx, id)
+}
+
+// UpdateStatus overwrites the name, order and category of a status; an
+// order of 0 keeps its place. Taking the name of another status is a
+// conflict, and so is moving a seeded status to another category.
+func (s *SQLiteStore) UpdateStatus(ctx context.Context, id int64, status models.Status) (models.Status, error) {
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		current, err := getStatus(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if isSeededStatus(id) && status.Category != current.Category {
+			return conflictf("%s is a default status and stays in the %s category", current.Name, current.Category)
+		}
+		if status.Order == 0 {
+			status.Order = current.Order
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE statuses SET name=?, position=?, category=?, updatedAt=strftime('%s', 'now') WHERE id=?",
+			strings.TrimSpace(status.Name), status.Order, status.Category, id)
+		return storeError(err)
+	})
+	if err != nil {
+		return models.Status{}, err
+	}
+	return s.GetStatus(ctx, id)
+}
+
+// DeleteStatus deletes a status no todo is in, has been in, or can move to
+// or from in the configured workflow. The seeded statuses cannot be deleted.
+func (s *SQLiteStore) DeleteStatus(ctx context.Context, id int64) (int64, error) {
+	var rowsAffected int64
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		status, err := getStatus(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if isSeededStatus(id) {
+			return conflictf("%s is a default status and cannot be deleted", status.Name)
+		}
+
+		var used int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM todos WHERE status=?", id).Scan(&used); err != nil {
+			return err
+		}
+		if used > 0 {
+			return conflictf("status %s is used by %d todos, move them to another status first", status.Name, used)
+		}
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(DISTINCT todo_id) FROM status_history WHERE fromStatus=? OR toStatus=?", id, id).Scan(&used); err != nil {
+			return err
+		}
+		if used > 0 {
+			return conflictf("status %s is in the status history of %d todos", status.Name, used)
+		}
+		if s.workflow.mentions(models.ToDoStatus(id)) {
+			return conflictf("status %s is part of the configured workflow", status.Name)
+		}
+
+		response, err := tx.ExecContext(ctx, "DELETE FROM statuses WHERE id=?", id)
+		if err != nil {
+			return fmt.Errorf("deleteStatus: unable to execute the query: %w", err)
+		}
+		rowsAffected, err = response.RowsAffected()
+		return err
+	})
+	return rowsAffected, err
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go-todo/models"
+)
+
+// TodoStore is the storage backend behind the API handlers. It covers todos,
+// tags and the associations between them so that the handlers never talk to
+// a database directly.
+type TodoStore interface {
+	// Todos
+	InsertTodo(ctx context.Context, todo models.ToDo) (models.ToDo, error)
+	GetTodo(ctx context.Context, id int64) (models.ToDo, error)
+	// GetAllTodos returns one page of the todos matching filter and the
+	// cursor of the next page, empty when this is the last one
+	GetAllTodos(ctx context.Context, filter TodoFilter, page Page) ([]models.ToDo, string, error)
+	// UpdateTodo overwrites a todo; force starts or closes it even while it
+	// waits on open blockers
+	UpdateTodo(ctx context.Context, id int64, todo models.ToDo, force bool) (models.ToDo, error)
+	// PatchTodo writes only the fields set in patch
+	PatchTodo(ctx context.Context, id int64, patch TodoPatch) (models.ToDo, error)
+	// Occurrences returns the due dates of the next n occurrences of a
+	// recurring todo
+	Occurrences(ctx context.Context, id int64, n int) ([]string, error)
+	// StatusHistory returns the status changes of a todo, oldest first
+	StatusHistory(ctx context.Context, id int64) ([]models.StatusChange, error)
+	// SubtreeTodos returns a todo followed by its subtasks at any depth
+	SubtreeTodos(ctx context.Context, id int64) ([]models.ToDo, error)
+	DeleteTodo(ctx context.Context, id int64) (int64, error)
+	// SearchTodos runs a full-text query over titles and descriptions and
+	// returns the best limit matches, ErrUnavailable without a search index
+	SearchTodos(ctx context.Context, query string, limit int) ([]models.SearchResult, error)
+
+	// Tags
+	// InsertTag creates a tag, or returns the existing one of the same name
+	InsertTag(ctx context.Context, tag models.Tag) (models.Tag, error)
+	GetTag(ctx context.Context, id int64) (models.Tag, error)
+	GetTagByName(ctx context.Context, name string) (models.Tag, error)
+	UpdateTag(ctx context.Context, id int64, tag models.Tag) (models.Tag, error)
+	// PatchTag writes only the fields set in patch; renaming a tag renames
+	// the tags in its namespace with it
+	PatchTag(ctx context.Context, id int64, patch TagPatch) (models.Tag, error)
+	// SubtreeTags returns a tag and the tags below it, at most depth levels
+	// down unless depth is 0
+	SubtreeTags(ctx context.Context, id int64, depth int) ([]models.Tag, error)
+	// GetAllTags returns one page of tags with their usage and the cursor of
+	// the next page, empty when this is the last one
+	GetAllTags(ctx context.Context, page Page) ([]models.TagUsage, string, error)
+	// SuggestTags returns the best limit tags to complete prefix
+	SuggestTags(ctx context.Context, prefix string, limit int) ([]models.TagUsage, error)
+	DeleteTag(ctx context.Context, id int64) (int64, error)
+	// MergeTags folds the source tags into the target tag
+	MergeTags(ctx context.Context, targetID int64, sources TagRefs) (TagMerge, error)
+
+	// Associations
+	GetTagsOfTodo(ctx context.Context, todoID int64) ([]models.Tag, error)
+	AssociateTag(ctx context.Context, tagID int64, todoID int64) (int64, error)
+	DissociateTag(ctx context.Context, tagID int64, todoID int64) error
+	// ReplaceTags sets the tags of a todo to exactly refs, atomically
+	ReplaceTags(ctx context.Context, todoID int64, refs TagRefs) ([]models.Tag, error)
+
+	// Statuses
+	// GetAllStatuses returns every status in board order
+	GetAllStatuses(ctx context.Context) ([]models.Status, error)
+	GetStatus(ctx context.Context, id int64) (models.Status, error)
+	InsertStatus(ctx context.Context, status models.Status) (models.Status, error)
+	UpdateStatus(ctx context.Context, id int64, status models.Status) (models.Status, error)
+	// DeleteStatus deletes a status no todo is in, ErrConflict otherwise
+	DeleteStatus(ctx context.Context, id int64) (int64, error)
+
+	// Dependencies
+	// AddBlocker makes todoID wait on blockerID, ErrConflict if that closes
+	// a cycle
+	AddBlocker(ctx context.Context, todoID, blockerID int64) error
+	RemoveBlocker(ctx context.Context, todoID, blockerID int64) error
+	// GetBlockers returns the todos that todoID waits on
+	GetBlockers(ctx context.Context, todoID int64) ([]models.ToDo, error)
+
+	// Close releases the resources held by the store
+	Close() error
+}
+
+// TagMerge reports the outcome of merging tags
+type TagMerge struct {
+	// Tag is the target tag after the merge
+	Tag models.Tag `json:"tag"`
+	// MergedTags lists the ids of the deleted source tags
+	MergedTags []int64 `json:"mergedTags"`
+	// AffectedTodos counts the todos that carried any of the source tags
+	AffectedTodos int64 `json:"affectedTodos"`
+}
+
+// TagRefs names tags by id or by name
+type TagRefs struct {
+	IDs   []int64
+	Names []string
+}
+
+// UnmarshalJSON reads a list mixing tag ids and names, e.g. [1, "urgent"]
+func (r *TagRefs) UnmarshalJSON(data []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("tags must be a list of tag ids and names")
+	}
+
+	for _, item := range items {
+		var id int64
+		var name string
+		switch {
+		case json.Unmarshal(item, &id) == nil:
+			r.IDs = append(r.IDs, id)
+		case json.Unmarshal(item, &name) == nil:
+			r.Names = append(r.Names, name)
+		default:
+			return fmt.Errorf("tags must be a list of tag ids and names, got %s", item)
+		}
+	}
+	return nil
+}
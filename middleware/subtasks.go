@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go-todo/models"
+)
+
+// nullID stores the id 0 as NULL
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+// checkParent makes sure todo id can become a subtask of parentID: the
+// parent must exist and must not be the todo itself or one of its subtasks.
+// id is 0 for a todo that is yet to be inserted.
+func checkParent(ctx context.Context, q querier, id, parentID int64) error {
+	if parentID == id {
+		return conflictf("todo %v cannot be its own parent", id)
+	}
+
+	var exists bool
+	if err := q.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM todos WHERE id=?)", parentID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return invalidf("parent todo %v does not exist", parentID)
+	}
+	if id == 0 {
+		return nil
+	}
+
+	// walk up from the new parent; meeting the todo would close a cycle
+	var cycle bool
+	err := q.QueryRowContext(ctx, `WITH RECURSIVE ancestors(id) AS (
+			SELECT ?
+			UNION SELECT t.parentId FROM todos t JOIN ancestors a ON t.id = a.id WHERE t.parentId IS NOT NULL
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE id=?)`, parentID, id).Scan(&cycle)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return conflictf("todo %v cannot be moved below its own subtask %v", id, parentID)
+	}
+	return nil
+}
+
+// closeFinishedParents moves the parent of todo id to Closed when it is
+// finished, see closeFinished
+func closeFinishedParents(ctx context.Context, tx *sql.Tx, w transitions, id int64) error {
+	var parentID sql.NullInt64
+	if err := tx.QueryRowContext(ctx, "SELECT parentId FROM todos WHERE id=?", id).Scan(&parentID); err != nil {
+		return err
+	}
+	if !parentID.Valid {
+		return nil
+	}
+	return closeFinished(ctx, tx, w, parentID.Int64)
+}
+
+// closeFinished moves todo id to Closed when it asks for autoClose, has
+// subtasks and all of them are done, none of its blockers is open and the
+// workflow lets it close, then does the same for its parent, and so on. A
+// todo it closes recurs like one closed by a patch.
+func closeFinished(ctx context.Context, tx *sql.Tx, w transitions, id int64) error {
+	closed, err := getStatus(ctx, tx, int64(models.Closed))
+	if err != nil {
+		return err
+	}
+
+	for {
+		var status models.ToDoStatus
+		var parentID sql.NullInt64
+		var finished bool
+		err := tx.QueryRowContext(ctx, `SELECT p.status, p.parentId, p.autoClose AND p.status NOT IN `+doneStatuses+`
+				AND EXISTS (SELECT 1 FROM todos c WHERE c.parentId = p.id)
+				AND NOT EXISTS (SELECT 1 FROM todos c WHERE c.parentId = p.id AND c.status NOT IN `+doneStatuses+`)
+			FROM todos p WHERE p.id=?`, id).Scan(&status, &parentID, &finished)
+		if err != nil {
+			return err
+		}
+		if !finished {
+			return nil
+		}
+		current, err := getStatus(ctx, tx, int64(status))
+		if err != nil {
+			return err
+		}
+		if !w.allows(current, closed) {
+			return nil
+		}
+		if err := checkUnblocked(ctx, tx, id, current, closed); errors.Is(err, ErrConflict) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE todos SET status=?, updatedAt=strftime('%s', 'now') WHERE id=?", models.Closed, id); err != nil {
+			return err
+		}
+		if err := scheduleNext(ctx, tx, id); err != nil {
+			return err
+		}
+		if !parentID.Valid {
+			return nil
+		}
+		id = parentID.Int64
+	}
+}
+
+// attachProgress fills in the subtask progress of every todo that has
+// subtasks, with a single query
+func attachProgress(ctx context.Context, q querier, todos []models.ToDo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+
+	index := make(map[int64]int, len(todos))
+	args := make([]interface{}, len(todos))
+	for i, todo := range todos {
+		index[todo.ID] = i
+		args[i] = todo.ID
+	}
+
+	rows, err := q.QueryContext(ctx, `WITH RECURSIVE subtasks(root, id, status) AS (
+			SELECT parentId, id, status FROM todos WHERE parentId IN (`+placeholders(len(args))+`)
+			UNION ALL SELECT s.root, t.id, t.status FROM todos t JOIN subtasks s ON t.parentId = s.id
+		)
+		SELECT root, COUNT(*), SUM(status IN `+doneStatuses+`) FROM subtasks GROUP BY root`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var root int64
+		var progress models.Progress
+		if err := rows.Scan(&root, &progress.Subtasks, &progress.Closed); err != nil {
+			return fmt.Errorf("attachProgress: unable to scan the row: %w", err)
+		}
+		progress.Percent = progress.Closed * 100 / progress.Subtasks
+		todos[index[root]].Progress = &progress
+	}
+	return rows.Err()
+}
+
+// SubtreeTodos returns a todo followed by all of its subtasks, depth first
+func (s *SQLiteStore) SubtreeTodos(ctx context.Context, id int64) ([]models.ToDo, error) {
+	rows, err := s.db.QueryContext(ctx, `WITH RECURSIVE subtree(id, path) AS (
+			SELECT id, printf('%020d', id) FROM todos WHERE id=?
+			UNION ALL SELECT t.id, s.path || '/' || printf('%020d', t.id) FROM todos t JOIN subtree s ON t.parentId = s.id
+		)
+		SELECT `+todoColumns+` FROM subtree s JOIN todos t ON t.id = s.id ORDER BY s.path`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var todos []models.ToDo
+	for rows.Next() {
+		var todo models.ToDo
+		if err := rows.Scan(todoFields(&todo)...); err != nil {
+			return nil, fmt.Errorf("subtreeTodos: unable to scan the row: %w", err)
+		}
+		todos = append(todos, todo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(todos) == 0 {
+		return nil, fmt.Errorf("todo %v: %w", id, ErrNotFound)
+	}
+
+	if err := s.attachTags(ctx, todos); err != nil {
+		return nil, err
+	}
+	return todos, attachProgress(ctx, s.db, todos)
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"go-todo/models"
+)
+
+// categoryTransitions is the workflow unless one is configured, by status
+// category: work starts, stops or finishes, finished work can only be
+// reopened or finished differently, and statuses of the same category are
+// interchangeable. For the seeded statuses that is Open to In Progress or
+// Closed, In Progress to Open or Closed, and Closed back to Open.
+var categoryTransitions = map[models.StatusCategory][]models.StatusCategory{
+	models.CategoryOpen:   {models.CategoryOpen, models.CategoryActive, models.CategoryDone},
+	models.CategoryActive: {models.CategoryOpen, models.CategoryActive, models.CategoryDone},
+	models.CategoryDone:   {models.CategoryOpen, models.CategoryDone},
+}
+
+// Workflow is a configured state machine of todo statuses, the moves a todo
+// may make from one status to another. Statuses are named by id or name and
+// resolved by SQLiteStore.SetWorkflow.
+type Workflow struct {
+	moves [][2]string
+}
+
+// ParseWorkflow reads transitions written "from -> to". No transitions give
+// the default workflow, see categoryTransitions.
+func ParseWorkflow(transitions []string) (Workflow, error) {
+	var w Workflow
+	for _, t := range transitions {
+		from, to, ok := strings.Cut(t, "->")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return Workflow{}, fmt.Errorf("transition %q must be written \"from -> to\"", t)
+		}
+		w.moves = append(w.moves, [2]string{from, to})
+	}
+	return w, nil
+}
+
+// transitions maps a status id to the ids a todo may move to from it, nil
+// for the default workflow
+type transitions map[models.ToDoStatus]map[models.ToDoStatus]bool
+
+// allows reports whether a todo may move from one status to another.
+// Staying in the same status is always allowed.
+func (t transitions) allows(from, to models.Status) bool {
+	if from.ID == to.ID {
+		return true
+	}
+	if t != nil {
+		return t[models.ToDoStatus(from.ID)][models.ToDoStatus(to.ID)]
+	}
+	for _, category := range categoryTransitions[from.Category] {
+		if category == to.Category {
+			return true
+		}
+	}
+	return false
+}
+
+// mentions reports whether a configured workflow has a transition from or
+// to status id
+func (t transitions) mentions(id models.ToDoStatus) bool {
+	for from, tos := range t {
+		if from == id || tos[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// change checks that todo id may move to status to and returns the status
+// it leaves and the one it enters
+func (t transitions) change(ctx context.Context, q querier, id int64, to models.ToDoStatus) (models.Status, models.Status, error) {
+	current, err := todoStatus(ctx, q, id)
+	if err != nil {
+		return models.Status{}, models.Status{}, err
+	}
+	from, err := getStatus(ctx, q, int64(current))
+	if err != nil {
+		return models.Status{}, models.Status{}, err
+	}
+	next, err := getStatus(ctx, q, int64(to))
+	if err != nil {
+		return from, next, invalidf("unknown status %d, see /api/status", to)
+	}
+
+	if !t.allows(from, next) {
+		return from, next, conflictf("a todo cannot move from %s to %s", from.Name, next.Name)
+	}
+	return from, next, nil
+}
+
+// SetWorkflow replaces the state machine that status changes must follow.
+// Its statuses must exist; statuses created later are outside of it.
+func (s *SQLiteStore) SetWorkflow(ctx context.Context, w Workflow) error {
+	if len(w.moves) == 0 {
+		s.workflow = nil
+		return nil
+	}
+
+	t := transitions{}
+	for _, move := range w.moves {
+		var ids [2]models.ToDoStatus
+		for i, ref := range move {
+			status, err := statusByRef(ctx, s.db, ref)
+			if err != nil {
+				return fmt.Errorf("transition %s -> %s: %w", move[0], move[1], err)
+			}
+			ids[i] = models.ToDoStatus(status.ID)
+		}
+		if t[ids[0]] == nil {
+			t[ids[0]] = map[models.ToDoStatus]bool{}
+		}
+		t[ids[0]][ids[1]] = true
+	}
+	s.workflow = t
+	return nil
+}
+
+// todoStatus returns the current status of todo id
+func todoStatus(ctx context.Context, q querier, id int64) (models.ToDoStatus, error) {
+	var status models.ToDoStatus
+	err := q.QueryRowContext(ctx, "SELECT status FROM todos WHERE id=?", id).Scan(&status)
+	if err == sql.ErrNoRows {
+		return status, fmt.Errorf("todo %v: %w", id, ErrNotFound)
+	}
+	return status, err
+}
+
+// StatusHistory returns the status changes of a todo, oldest first
+func (s *SQLiteStore) StatusHistory(ctx context.Context, id int64) ([]models.StatusChange, error) {
+	if _, err := todoStatus(ctx, s.db, id); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, fromStatus, toStatus, changedAt FROM status_history WHERE todo_id=? ORDER BY id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []models.StatusChange{}
+	for rows.Next() {
+		var change models.StatusChange
+		if err := rows.Scan(&change.ID, &change.From, &change.To, &change.ChangedAt); err != nil {
+			return nil, fmt.Errorf("statusHistory: unable to scan the row: %w", err)
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-todo/middleware"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+const migrateUsage = `usage: go-todo migrate <command>
+
+commands:
+  status          list migrations and whether they are applied
+  up              apply every pending migration
+  to <version>    migrate up or down to the given version
+  down [steps]    roll back the last steps migrations (default 1)`
+
+// runMigrate implements the "migrate" sub-command
+func runMigrate(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	db, err := middleware.OpenDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := middleware.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range status {
+			appliedAt := "pending"
+			switch {
+			case s.Applied:
+				appliedAt = s.AppliedAt
+			case !s.Supported:
+				appliedAt = fmt.Sprintf("skipped, needs sqlite %s", s.Requires)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+	case "up":
+		return migrator.Up(ctx)
+	case "to":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		return migrator.MigrateTo(ctx, version)
+	case "down":
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		return migrator.Rollback(ctx, steps)
+	default:
+		return errors.New(migrateUsage)
+	}
+}
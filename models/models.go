@@ -1,18 +1,25 @@
 package models
 
-import "reflect"
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
 
-// ToDoStatus type
+// ToDoStatus is the id of a Status. Statuses are stored, so clients can add
+// their own; the constants are the ids of the ones every database starts with.
 type ToDoStatus int
 
-// TodoStatus enum definitions
+// TodoStatus enum definitions, the seeded statuses
 const (
 	Open ToDoStatus = iota
 	InProgress
 	Closed
 )
 
-// Return ToDoStatus string
+// Return ToDoStatus string, the original name of a seeded status. Statuses
+// may be renamed or added, their names are in the statuses table.
 func (s ToDoStatus) String() string {
 	switch s {
 	case 0:
@@ -26,6 +33,59 @@ func (s ToDoStatus) String() string {
 	}
 }
 
+// Priority type
+type Priority int
+
+// Priority enum definitions, from least to most important
+const (
+	PriorityNone Priority = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+	PriorityUrgent
+)
+
+// Return Priority string
+func (p Priority) String() string {
+	switch p {
+	case 0:
+		return "None"
+	case 1:
+		return "Low"
+	case 2:
+		return "Medium"
+	case 3:
+		return "High"
+	case 4:
+		return "Urgent"
+	default:
+		return "Unknown"
+	}
+}
+
+// Valid reports whether p is one of the defined priorities
+func (p Priority) Valid() bool {
+	return p >= PriorityNone && p <= PriorityUrgent
+}
+
+// ParsePriority reads a priority from its number or its name, ignoring case
+func ParsePriority(v string) (Priority, error) {
+	if n, err := strconv.Atoi(v); err == nil {
+		p := Priority(n)
+		if !p.Valid() {
+			return p, fmt.Errorf("unknown priority %v", n)
+		}
+		return p, nil
+	}
+
+	for _, p := range []Priority{PriorityNone, PriorityLow, PriorityMedium, PriorityHigh, PriorityUrgent} {
+		if strings.EqualFold(strings.TrimSpace(v), p.String()) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q", v)
+}
+
 // ToDo struct
 type ToDo struct {
 	ID          int64      `json:"id"`
@@ -34,14 +94,94 @@ type ToDo struct {
 	CreatedAt   string     `json:"createdAt"`
 	UpdatedAt   string     `json:"updatedAt"`
 	Status      ToDoStatus `json:"status"`
-	Tags        []Tag      `json:"tags,omitempty"`
+	Priority    Priority   `json:"priority"`
+	// DueAt is a YYYY-MM-DD date or an RFC 3339 time, empty when not due
+	DueAt string `json:"dueAt,omitempty"`
+	// ParentID is the todo this one is a subtask of, 0 for none
+	ParentID int64 `json:"parentId,omitempty"`
+	// AutoClose closes the todo once all of its subtasks are Closed
+	AutoClose bool `json:"autoClose,omitempty"`
+	// Recurrence is an RFC 5545 RRULE such as FREQ=WEEKLY;BYDAY=MO, empty
+	// for a one-off todo
+	Recurrence string `json:"recurrence,omitempty"`
+	// Progress sums up the subtasks, nil when there are none
+	Progress *Progress `json:"progress,omitempty"`
+	Tags     []Tag     `json:"tags,omitempty"`
+}
+
+// StatusChange is one entry of the status history of a todo
+type StatusChange struct {
+	ID        int64      `json:"id"`
+	From      ToDoStatus `json:"from"`
+	To        ToDoStatus `json:"to"`
+	ChangedAt string     `json:"changedAt"`
+}
+
+// Progress rolls up the subtasks of a todo, at any depth
+type Progress struct {
+	Subtasks int `json:"subtasks"`
+	Closed   int `json:"closed"`
+	// Percent is the share of Closed subtasks, rounded down
+	Percent int `json:"percent"`
+}
+
+// StatusCategory groups statuses by what they mean for the work
+type StatusCategory string
+
+// Status categories
+const (
+	// CategoryOpen is work not started yet
+	CategoryOpen StatusCategory = "open"
+	// CategoryActive is work under way
+	CategoryActive StatusCategory = "active"
+	// CategoryDone is work that needs nothing more, done or dropped
+	CategoryDone StatusCategory = "done"
+)
+
+// Valid reports whether c is one of the known categories
+func (c StatusCategory) Valid() bool {
+	return c == CategoryOpen || c == CategoryActive || c == CategoryDone
+}
+
+// Status is a state a todo can be in
+type Status struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// Order places the status on a board, lower first
+	Order     int            `json:"order"`
+	Category  StatusCategory `json:"category"`
+	CreatedAt string         `json:"createdAt"`
+	UpdatedAt string         `json:"updatedAt"`
 }
 
 // Tag struct
 type Tag struct {
-	ID        int64  `json:"id"`
-	Name      string `json:"name"`
-	CreatedAt string `json:"createdAt"`
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Color       string `json:"color"`
+	Description string `json:"description"`
+	CreatedAt   string `json:"createdAt"`
+	UpdatedAt   string `json:"updatedAt"`
+}
+
+// TagUsage is a tag with statistics about its use
+type TagUsage struct {
+	Tag
+	// UsageCount is the number of todos carrying the tag
+	UsageCount int64 `json:"usageCount"`
+	// LastUsedAt is when the tag was last put on a todo, empty if never
+	LastUsedAt string `json:"lastUsedAt,omitempty"`
+}
+
+// SearchResult is a todo matching a full-text search
+type SearchResult struct {
+	ToDo
+	// Rank orders results by relevance, lower is better
+	Rank float64 `json:"rank"`
+	// TitleHighlight is the title with matching terms wrapped in <mark> tags
+	TitleHighlight string `json:"titleHighlight"`
+	// Snippet is an excerpt of the description around the matching terms
+	Snippet string `json:"snippet"`
 }
 
 // IsEmpty will return if todo is empty
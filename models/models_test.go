@@ -28,8 +28,26 @@ func TestStatus(t *testing.T) {
 	}
 }
 
+func TestParsePriority(t *testing.T) {
+	for input, expected := range map[string]Priority{"0": PriorityNone, "none": PriorityNone, "Low": PriorityLow, "2": PriorityMedium, "HIGH": PriorityHigh, "urgent": PriorityUrgent} {
+		p, err := ParsePriority(input)
+		if err != nil {
+			t.Error("Unable to parse priority", input, err)
+		}
+		if p != expected {
+			t.Error("Parsed priority does not match", input, p)
+		}
+	}
+
+	for _, input := range []string{"5", "-1", "critical", ""} {
+		if _, err := ParsePriority(input); err == nil {
+			t.Error("Expected an error parsing priority", input)
+		}
+	}
+}
+
 func TestTodoStruct(t *testing.T) {
-	todo := ToDo{12, "test", "test description", "2020-06-12T14:05:26Z", "2020-06-12T14:05:26Z", 0, []Tag{}}
+	todo := ToDo{ID: 12, Title: "test", Description: "test description", CreatedAt: "2020-06-12T14:05:26Z", UpdatedAt: "2020-06-12T14:05:26Z", Status: 0, Tags: []Tag{}}
 
 	if todo.IsEmpty() {
 		t.Error("New todo is empty")
@@ -41,7 +59,7 @@ func TestTodoStruct(t *testing.T) {
 }
 
 func TestChangeTodoStatus(t *testing.T) {
-	todo := ToDo{12, "test", "test description", "2020-06-12T14:05:26Z", "2020-06-12T14:05:26Z", 0, []Tag{}}
+	todo := ToDo{ID: 12, Title: "test", Description: "test description", CreatedAt: "2020-06-12T14:05:26Z", UpdatedAt: "2020-06-12T14:05:26Z", Status: 0, Tags: []Tag{}}
 
 	todo.Status = InProgress
 	if todo.Status != InProgress {
@@ -55,7 +73,7 @@ func TestChangeTodoStatus(t *testing.T) {
 }
 
 func TestTagStruct(t *testing.T) {
-	tag := Tag{1, "tag", "2020-06-12T14:05:26Z"}
+	tag := Tag{ID: 1, Name: "tag", CreatedAt: "2020-06-12T14:05:26Z"}
 
 	if tag.IsEmpty() {
 		t.Error("New tag is empty")
@@ -68,11 +86,11 @@ func TestTagStruct(t *testing.T) {
 
 func TestAssociateTagToTodo(t *testing.T) {
 	tags := []Tag{
-		Tag{1, "tagOne", "2020-06-12T14:05:26Z"},
-		Tag{2, "tagTwo", "2020-06-12T14:05:26Z"},
+		Tag{ID: 1, Name: "tagOne", CreatedAt: "2020-06-12T14:05:26Z"},
+		Tag{ID: 2, Name: "tagTwo", CreatedAt: "2020-06-12T14:05:26Z"},
 	}
 
-	todo := ToDo{12, "test", "test description", "2020-06-12T14:05:26Z", "2020-06-12T14:05:26Z", 0, tags}
+	todo := ToDo{ID: 12, Title: "test", Description: "test description", CreatedAt: "2020-06-12T14:05:26Z", UpdatedAt: "2020-06-12T14:05:26Z", Status: 0, Tags: tags}
 
 	if len(todo.Tags) != 2 {
 		t.Error("Todo tags is not of expected length", todo)
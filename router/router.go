@@ -2,28 +2,57 @@ package router
 
 import (
 	"go-todo/middleware"
+	"net/http"
 
 	"github.com/gorilla/mux"
 )
 
 // Router is exported and used in main.go
-func Router() *mux.Router {
+func Router(h *middleware.Handler, corsOrigins []string) *mux.Router {
 
 	router := mux.NewRouter()
+	router.Use(middleware.Recover, mux.CORSMethodMiddleware(router), middleware.CORS(corsOrigins))
+	router.NotFoundHandler = http.HandlerFunc(middleware.NotFound)
+	router.MethodNotAllowedHandler = http.HandlerFunc(middleware.MethodNotAllowed)
 
-	// Todo routes
-	router.HandleFunc("/api/todo/{id}", middleware.GetTodo).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/todo", middleware.GetAllTodos).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/todo", middleware.CreateTodo).Methods("POST", "OPTIONS")
-	router.HandleFunc("/api/todo/{id}", middleware.UpdateTodo).Methods("PUT", "OPTIONS")
-	router.HandleFunc("/api/todo/{id}", middleware.DeleteTodo).Methods("DELETE", "OPTIONS")
+	// Todo routes, search comes first so that it is not taken for an {id}
+	router.HandleFunc("/api/todo/search", h.SearchTodos).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/todo/{view:overdue|today|upcoming}", h.DueTodos).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/todo/{id}", h.GetTodo).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/todo", h.GetAllTodos).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/todo", h.CreateTodo).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/todo/{id}", h.UpdateTodo).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/api/todo/{id}", h.PatchTodo).Methods("PATCH", "OPTIONS")
+	router.HandleFunc("/api/todo/{id}", h.DeleteTodo).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/api/todo/{id}/tags", h.ReplaceTodoTags).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/api/todo/{id}/children", h.GetChildTodos).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/todo/{id}/subtree", h.GetTodoSubtree).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/todo/{id}/blockers", h.GetBlockers).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/todo/{id}/blockers/{blockerID}", h.AddBlocker).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/todo/{id}/blockers/{blockerID}", h.RemoveBlocker).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/api/todo/{id}/occurrences", h.GetOccurrences).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/todo/{id}/history", h.GetTodoHistory).Methods("GET", "OPTIONS")
 
 	// Tag routes
-	router.HandleFunc("/api/tag/{id}", middleware.GetTag).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/tag", middleware.GetAllTags).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/tag", middleware.AddTag).Methods("POST", "OPTIONS")
-	router.HandleFunc("/api/tag/{id}", middleware.DeleteTag).Methods("DELETE", "OPTIONS")
-	router.HandleFunc("/api/tag/todo/{tagID}/{todoID}", middleware.AssociateTag).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/tag/lookup", h.LookupTag).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/tag/suggest", h.SuggestTags).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/tag/{id}", h.GetTag).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/tag", h.GetAllTags).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/tag", h.AddTag).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/tag/{id}", h.UpdateTag).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/api/tag/{id}", h.PatchTag).Methods("PATCH", "OPTIONS")
+	router.HandleFunc("/api/tag/{id}", h.DeleteTag).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/api/tag/{id}/subtree", h.SubtreeTags).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/tag/{id}/merge", h.MergeTags).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/tag/todo/{tagID}/{todoID}", h.AssociateTag).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/tag/todo/{tagID}/{todoID}", h.DissociateTag).Methods("DELETE", "OPTIONS")
+
+	// Status routes
+	router.HandleFunc("/api/status/{id}", h.GetStatus).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/status", h.GetAllStatuses).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/status", h.AddStatus).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/status/{id}", h.UpdateStatus).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/api/status/{id}", h.DeleteStatus).Methods("DELETE", "OPTIONS")
 
 	return router
 }